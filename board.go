@@ -0,0 +1,39 @@
+package get2ch
+
+import (
+	"context"
+	"github.com/tanaton/get2ch-go/parser"
+)
+
+// スレッド一覧を取得して解析する
+func (g2ch *Get2ch) GetThreadList() ([]parser.ThreadEntry, error) {
+	return g2ch.GetThreadListContext(context.Background())
+}
+
+func (g2ch *Get2ch) GetThreadListContext(ctx context.Context) ([]parser.ThreadEntry, error) {
+	if g2ch.thread != "" {
+		return nil, ErrNotBoard
+	}
+	data, err := g2ch.GetDataContext(ctx)
+	if err != nil {
+		// エラー時のデータは解析しない
+		return nil, err
+	}
+	return parser.ParseSubjectDecoder(data, parser.ENC_SJIS, g2ch.client.codec)
+}
+
+// SETTING.TXTを取得して解析する
+// SETTING.TXTはキャッシュの有効期間内ならキャッシュを使う
+func (g2ch *Get2ch) GetBoardSettings() (*parser.BoardSettings, error) {
+	return g2ch.GetBoardSettingsContext(context.Background())
+}
+
+func (g2ch *Get2ch) GetBoardSettingsContext(ctx context.Context) (*parser.BoardSettings, error) {
+	// 取得中の状態は作業用の複製に持たせる
+	stf, err := g2ch.worker().getSettingFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// getSettingFileはUTF-8で返す
+	return parser.ParseSetting(stf, parser.ENC_UTF8), nil
+}
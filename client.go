@@ -0,0 +1,259 @@
+package get2ch
+
+import (
+	"context"
+	"fmt"
+	"github.com/tanaton/get2ch-go/internal/codec"
+	"github.com/tanaton/get2ch-go/process"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const MENU_UPDATE_TIME = 1 * time.Hour // 板一覧の更新間隔
+
+// Client生成時の設定
+type Options struct {
+	Cache               Cache             // datの保存先
+	Salami              *Salami           // 中継サーバ nilの場合は直接接続
+	UserAgent           string            // 空の場合はUSER_AGENT
+	HTTPClient          *http.Client      // nilの場合は標準のクライアントを使う
+	Transport           http.RoundTripper // nil以外の場合はHTTPClientのTransportより優先する
+	Timeouts            Timeouts          // 0の項目は標準の設定 負の項目は制限しない
+	Limits              Limits            // 0の項目は標準の設定
+	Retry               RetryPolicy       // nilの場合は再試行しない
+	RateLimit           RateLimit         // 接続先毎の取得間隔 0の項目は制限しない
+	DisableKeepAlives   bool              // trueの場合は毎回接続を切る
+	MaxIdleConnsPerHost int               // ホスト毎に保持する接続数 0の場合はMAX_IDLE_CONNS_PER_HOST
+	CategoryFilter      map[string]bool   // 板一覧から除外するカテゴリ nilの場合は標準の設定
+	ServerFilter        map[string]bool   // 板一覧から除外するサーバ nilの場合は標準の設定
+	Codec               CodecMode         // SJIS-winで変換できない文字の扱い
+	SkipMenuFetch       bool              // trueの場合は生成時に板一覧を取得せず、保存済みの板一覧を使う
+}
+
+// 設定ごとの管理機能
+// 複数のClientを同じプロセス内で使い分けることができる
+type Client struct {
+	cache       Cache
+	salami      string
+	user_agent  string
+	http_client *http.Client
+	keepalive   bool
+	timeouts    Timeouts
+	limits      Limits
+	retry       RetryPolicy
+	limiter     *rateLimiter
+	fetching    *keyLock // 取得中のデータ
+	stats       connStats
+	catekill    map[string]bool
+	sabakill    map[string]bool
+	codec       codec.Codec // SJIS-win
+	menu        *Menu
+	boardName   *process.BoardNameBox
+	bbnCache    *process.BBNCacheBox
+	ctx         context.Context // Closeで中断される
+	stop        context.CancelFunc
+	stopped     chan struct{} // 定期更新の終了
+	mux         sync.RWMutex
+}
+
+var g_once sync.Once
+var g_client *Client
+
+// Clientの生成
+// 板一覧の取得を行うため、呼び出しには時間がかかる
+// 使い終わったらCloseを呼び出すこと
+func NewClient(opt Options) *Client {
+	return NewClientContext(context.Background(), opt)
+}
+
+// ctxが終了した場合もCloseと同じく停止する
+// 生成時の板一覧の取得もctxで中断できる
+func NewClientContext(ctx context.Context, opt Options) *Client {
+	c := &Client{
+		cache:      opt.Cache,
+		salami:     salamiString(opt.Salami),
+		user_agent: opt.UserAgent,
+		keepalive:  !opt.DisableKeepAlives,
+		timeouts:   defaultTimeouts().merge(opt.Timeouts),
+		limits:     defaultLimits().merge(opt.Limits),
+		retry:      opt.Retry,
+		limiter:    newRateLimiter(opt.RateLimit),
+		fetching:   newKeyLock(),
+		catekill:   opt.CategoryFilter,
+		sabakill:   opt.ServerFilter,
+		codec:      codec.NewCP932(opt.Codec),
+	}
+	if c.user_agent == "" {
+		c.user_agent = USER_AGENT
+	}
+	if c.catekill == nil {
+		c.catekill = catekill
+	}
+	if c.sabakill == nil {
+		c.sabakill = sabakill
+	}
+	c.http_client = c.newHttpClient(opt)
+	c.ctx, c.stop = context.WithCancel(ctx)
+	c.stopped = make(chan struct{})
+	c.boardName = process.NewBoardNameBox()
+	c.bbnCache = process.NewBBNCacheBox()
+	// 板一覧の取得と定期更新
+	if opt.SkipMenuFetch {
+		c.loadSavedMenu()
+	} else {
+		c.refreshMenu(c.ctx)
+	}
+	go c.menuLoop()
+	return c
+}
+
+// 定期更新を止める
+// 取得中の板一覧の更新も中断し、終了するまで待つ
+func (c *Client) Close() error {
+	c.stop()
+	<-c.stopped
+	return nil
+}
+
+// 板一覧の定期更新
+func (c *Client) menuLoop() {
+	ticker := time.NewTicker(MENU_UPDATE_TIME)
+	defer ticker.Stop()
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.ctx.Done():
+			c.boardName.Close()
+			c.bbnCache.Close()
+			return
+		case <-ticker.C:
+			c.refreshMenu(c.ctx)
+		}
+	}
+}
+
+func salamiString(s *Salami) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d/", s.Host, s.Port)
+}
+
+func (c *Client) SetSalami(s *Salami) {
+	c.mux.Lock()
+	c.salami = salamiString(s)
+	c.mux.Unlock()
+}
+
+func (c *Client) SetCache(cache Cache) {
+	if cache == nil {
+		return
+	}
+	c.mux.Lock()
+	c.cache = cache
+	c.mux.Unlock()
+}
+
+func (c *Client) SetUserAgent(ua string) {
+	c.mux.Lock()
+	c.user_agent = ua
+	c.mux.Unlock()
+}
+
+func (c *Client) getCache() Cache {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.cache
+}
+
+func (c *Client) getSalami() string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.salami
+}
+
+func (c *Client) getUserAgent() string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.user_agent
+}
+
+func (c *Client) httpClient() *http.Client {
+	return c.http_client
+}
+
+// 取得用オブジェクトの生成
+func (c *Client) NewGet2ch(board, thread string) (*Get2ch, error) {
+	c.mux.RLock()
+	g2ch := &Get2ch{
+		size:       0,
+		mod:        0,
+		cache_mod:  0,
+		code:       0,
+		err:        nil,
+		server:     "",
+		board:      "",
+		thread:     "",
+		req_time:   time.Now().Unix(),
+		cache:      c.cache,
+		bourbon:    false, // バーボンフラグ
+		numlines:   0,
+		salami:     c.salami,
+		user_agent: c.user_agent,
+		client:     c,
+		timeouts:   c.timeouts,
+		limits:     c.limits,
+	}
+	c.mux.RUnlock()
+	g2ch.server = g2ch.GetServer(board)
+	g2ch.board = board
+	if _, err := strconv.ParseInt(thread, 10, 64); err == nil {
+		g2ch.thread = thread
+	}
+	return g2ch, nil
+}
+
+// get2ch管理機能の起動
+// 使用を開始する前に呼び出すこと
+func Start(c Cache, s *Salami) {
+	// サーバリスト更新
+	g_once.Do(func() {
+		g_client = NewClient(Options{
+			Cache:  c,
+			Salami: s,
+		})
+	})
+}
+
+// Startで生成したClientを返す
+// 起動前はnil
+func DefaultClient() *Client {
+	return g_client
+}
+
+func SetSalami(s *Salami) {
+	if g_client != nil {
+		g_client.SetSalami(s)
+	}
+}
+
+func SetCache(c Cache) {
+	if g_client != nil {
+		g_client.SetCache(c)
+	}
+}
+
+func SetUserAgent(ua string) {
+	if g_client != nil {
+		g_client.SetUserAgent(ua)
+	}
+}
+
+func NewGet2ch(board, thread string) (*Get2ch, error) {
+	if g_client == nil {
+		return nil, ErrNotStarted
+	}
+	return g_client.NewGet2ch(board, thread)
+}
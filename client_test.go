@@ -0,0 +1,51 @@
+package get2ch
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 生成時に取得しない場合は保存済みの板一覧を使い、Closeで全て止まる
+func TestClientClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache := NewMemoryCache(0, 0)
+	cache.SetData("", "", "", []byte(legacyMenu))
+	c := NewClient(Options{Cache: cache, SkipMenuFetch: true})
+	if b, ok := c.LookupBoard("newsplus"); !ok || b.Server != "news.2ch.net" {
+		t.Errorf("LookupBoard() = %v, %v", b, ok)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 二度呼んでもよい
+	c.Close()
+	// 停止後も呼び出せる
+	c.boardName.SetName("news", "ニュース速報")
+	c.bbnCache.SetBourbon("key")
+	waitGoroutines(t, before)
+}
+
+func TestClientContext(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewClientContext(ctx, Options{Cache: NewMemoryCache(0, 0), SkipMenuFetch: true})
+	if c.Menu() == nil {
+		t.Error("Menu() = nil")
+	}
+	cancel()
+	waitGoroutines(t, before)
+	c.Close()
+}
+
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines = %d, want <= %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
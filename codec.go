@@ -0,0 +1,15 @@
+package get2ch
+
+import (
+	"github.com/tanaton/get2ch-go/internal/codec"
+)
+
+// SJIS-winへ、またはSJIS-winから変換できない文字の扱い
+type CodecMode = codec.Mode
+
+const (
+	CODEC_REPLACE = codec.MODE_REPLACE // 置換文字にする
+	CODEC_NCR     = codec.MODE_NCR     // 数値文字参照にする
+	CODEC_ERROR   = codec.MODE_ERROR   // ErrCodecを返す
+	CODEC_RAW     = codec.MODE_RAW     // 元のバイト列を保持する(読み込みで書き戻せない符号を私用面の文字にする)
+)
@@ -0,0 +1,425 @@
+package get2ch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"github.com/tanaton/get2ch-go/unlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	SIZE_MEMO_MAX     = 65536 // 展開後のサイズを記録する最大件数
+	GZIP_SIZE_SI1     = 'G'   // メンバーの長さを記録するgzipの拡張フィールドの識別子
+	GZIP_SIZE_SI2     = 'L'
+	tGZIP_SIZE_OFFSET = 16 // メンバーの長さの位置 ヘッダ10バイト+XLEN2バイト+識別子とLEN4バイト
+)
+
+var errNoSize = errors.New("展開せずにサイズを求められません")
+
+// FileCacheの保存時の圧縮方式
+// 追記は圧縮したデータを末尾に足して行うため、連結したデータを続けて展開できること
+type Compressor interface {
+	Ext() string // ファイル名に付ける拡張子
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+// 展開せずに展開後のサイズを求められる場合に実装する
+// 求められない場合はエラーを返し、展開して数える
+type CompressedSizer interface {
+	UncompressedSize(r io.ReaderAt, size int64) (int64, error)
+	LastMember(r io.ReaderAt, size int64) (int64, error) // 最後に書き足した部分の開始位置
+}
+
+// gzip
+// 追記毎にメンバーを足す
+// 各メンバーの拡張フィールドにメンバーの長さを記録し、末尾のISIZEを辿って展開後のサイズを求める
+type GzipCompressor struct {
+	Level int // 圧縮レベル 0の場合は標準
+}
+
+func (gc GzipCompressor) Ext() string {
+	return ".gz"
+}
+
+func (gc GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := gc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gw := &gzipMemberWriter{w: w}
+	zw, err := gzip.NewWriterLevel(&gw.buf, level)
+	if err != nil {
+		return nil, err
+	}
+	// 長さはCloseで書き込む
+	zw.Extra = []byte{GZIP_SIZE_SI1, GZIP_SIZE_SI2, 4, 0, 0, 0, 0, 0}
+	gw.zw = zw
+	return gw, nil
+}
+
+func (gc GzipCompressor) NewReader(r io.Reader) (io.Reader, error) {
+	// 連結したメンバーも続けて読む
+	return gzip.NewReader(r)
+}
+
+func (gc GzipCompressor) UncompressedSize(r io.ReaderAt, size int64) (int64, error) {
+	total, _, err := gzipMembers(r, size)
+	return total, err
+}
+
+func (gc GzipCompressor) LastMember(r io.ReaderAt, size int64) (int64, error) {
+	_, last, err := gzipMembers(r, size)
+	return last, err
+}
+
+// メンバーのヘッダとISIZEだけを読み、展開後のサイズと最後のメンバーの位置を求める
+// 長さを記録していないメンバーや、途中で切れたメンバーがある場合はエラー
+func gzipMembers(r io.ReaderAt, size int64) (total, last int64, err error) {
+	var hdr [tGZIP_SIZE_OFFSET + 4]byte
+	var isize [4]byte
+	for off := int64(0); off < size; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return 0, 0, errNoSize
+		}
+		if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[3]&0x04 == 0 ||
+			binary.LittleEndian.Uint16(hdr[10:]) < 8 ||
+			hdr[12] != GZIP_SIZE_SI1 || hdr[13] != GZIP_SIZE_SI2 ||
+			binary.LittleEndian.Uint16(hdr[14:]) != 4 {
+			return 0, 0, errNoSize
+		}
+		n := int64(binary.LittleEndian.Uint32(hdr[tGZIP_SIZE_OFFSET:]))
+		if n < int64(len(hdr))+8 || off+n > size {
+			return 0, 0, errNoSize
+		}
+		if _, err := r.ReadAt(isize[:], off+n-4); err != nil {
+			return 0, 0, errNoSize
+		}
+		total += int64(binary.LittleEndian.Uint32(isize[:]))
+		last = off
+		off += n
+	}
+	return total, last, nil
+}
+
+// 1メンバー分を溜めて、長さを書き込んでから書き出す
+type gzipMemberWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	zw  *gzip.Writer
+}
+
+func (gw *gzipMemberWriter) Write(p []byte) (int, error) {
+	return gw.zw.Write(p)
+}
+
+func (gw *gzipMemberWriter) Close() error {
+	if err := gw.zw.Close(); err != nil {
+		return err
+	}
+	b := gw.buf.Bytes()
+	binary.LittleEndian.PutUint32(b[tGZIP_SIZE_OFFSET:], uint32(len(b)))
+	_, err := gw.w.Write(b)
+	return err
+}
+
+// 展開後のサイズの記録
+// 圧縮後のサイズと更新時間が変わっていなければ使う
+type sizeMemo struct {
+	csize int64 // 圧縮後のサイズ
+	mtime int64 // 更新時間(ナノ秒)
+	size  int64 // 展開後のサイズ
+	lf    bool  // 改行で終わっている 分からない場合はfalse
+}
+
+// 空の場合も改行で終わっているとみなす
+func endsLine(d []byte) bool {
+	return len(d) == 0 || d[len(d)-1] == '\n'
+}
+
+// 圧縮したファイルがあればそのパスを返す
+func (fc *FileCache) compressedPath(logfile string) (string, bool) {
+	if fc.Compress == nil {
+		return "", false
+	}
+	cp := logfile + fc.Compress.Ext()
+	_, err := os.Stat(cp)
+	return cp, err == nil
+}
+
+func (fc *FileCache) compress(d []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := fc.Compress.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(d); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 展開する
+// 途中で壊れている場合は読めたところまでとエラーを返す
+func (fc *FileCache) decompress(r io.Reader) ([]byte, error) {
+	zr, err := fc.Compress.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := zr.(io.Closer); ok {
+		defer c.Close()
+	}
+	return ioutil.ReadAll(zr)
+}
+
+func (fc *FileCache) getCompressed(cp string) ([]byte, error) {
+	fp, err := os.Open(cp)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	if err = unlib.LockFile(fp, false); err != nil {
+		return nil, err
+	}
+	defer unlib.UnlockFile(fp)
+	d, err := fc.decompress(fp)
+	if err == nil {
+		fc.memoSize(cp, int64(len(d)), endsLine(d))
+	}
+	return d, err
+}
+
+// 圧縮して置き換える
+// 圧縮していないファイルが残っていれば消す
+func (fc *FileCache) setCompressed(logfile string, d []byte) error {
+	cd, err := fc.compress(d)
+	if err != nil {
+		return err
+	}
+	cp := logfile + fc.Compress.Ext()
+	os.MkdirAll(path.Dir(logfile), 0666)
+	if err = fc.writeFile(cp, cd); err != nil {
+		return err
+	}
+	os.Remove(logfile)
+	fc.memoSize(cp, int64(len(d)), endsLine(d))
+	return nil
+}
+
+// 圧縮したデータを末尾に足す
+// 圧縮していないファイルしか無い場合は圧縮して置き換える
+func (fc *FileCache) appendCompressed(logfile string, d []byte) error {
+	cp := logfile + fc.Compress.Ext()
+	fp, err := os.OpenFile(cp, os.O_RDWR|os.O_APPEND, 0666)
+	if os.IsNotExist(err) {
+		old, rerr := ioutil.ReadFile(logfile)
+		if rerr != nil {
+			return rerr
+		}
+		return fc.setCompressed(logfile, append(old, d...))
+	} else if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if len(d) == 0 {
+		// 空のメンバーを足しても変わらない
+		return nil
+	}
+	if err = unlib.LockFile(fp, true); err != nil {
+		return err
+	}
+	defer unlib.UnlockFile(fp)
+	st, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	// 既に持っているファイルから展開後のサイズを求める
+	// 別に開くとロックが競合するため同じファイルを使う
+	size, err := fc.sizeOf(cp, fp, st)
+	if err != nil {
+		return err
+	}
+	cd, err := fc.compress(d)
+	if err != nil {
+		return err
+	}
+	if _, err = fp.Write(cd); err == nil && fc.Sync {
+		err = fp.Sync()
+	}
+	if err != nil {
+		// 書きかけの部分を取り除く
+		fp.Truncate(st.Size())
+		return err
+	}
+	fc.memoSize(cp, size+int64(len(d)), endsLine(d))
+	return nil
+}
+
+// 壊れたメンバー以降と、改行で終わらない最終行を取り除く
+// 完全な行が1行も無い場合は触らずにemptyを返す
+func (fc *FileCache) repairCompressed(cp string) (repaired, empty bool, err error) {
+	fp, err := os.OpenFile(cp, os.O_RDWR, 0666)
+	if err != nil {
+		return false, false, err
+	}
+	defer fp.Close()
+	if err = unlib.LockFile(fp, true); err != nil {
+		return false, false, err
+	}
+	defer unlib.UnlockFile(fp)
+	st, err := fp.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	ust, err := unlib.Stat(cp)
+	if err != nil {
+		return false, false, err
+	}
+	if m, ok := fc.getMemo(cp); ok && m.csize == st.Size() && m.mtime == st.ModTime().UnixNano() && m.lf {
+		// 自分で書いたまま変わっておらず、改行で終わっている
+		return false, false, nil
+	}
+	if cs, ok := fc.Compress.(CompressedSizer); ok {
+		if size, err := cs.UncompressedSize(fp, st.Size()); err == nil && fc.lastMemberEndsLine(cs, fp, st.Size()) {
+			// 全てのメンバーを書き終えていて、最終行も欠けていない
+			fc.setMemo(cp, sizeMemo{csize: st.Size(), mtime: st.ModTime().UnixNano(), size: size, lf: true})
+			return false, false, nil
+		}
+	}
+	d, derr := fc.decompress(fp)
+	end := bytes.LastIndexByte(d, '\n') + 1
+	if derr == nil && end == len(d) {
+		if _, ok := fc.Compress.(CompressedSizer); ok {
+			// 長さを記録していない形式の場合は書き直す
+			// 書き直さなくても壊れてはいないため、失敗しても続ける
+			if cd, err := fc.compress(d); err == nil && fc.writeFile(cp, cd) == nil {
+				os.Chtimes(cp, time.Unix(ust.Atime, 0).UTC(), time.Unix(ust.Mtime, 0).UTC())
+			}
+		}
+		fc.memoSize(cp, int64(len(d)), true)
+		return false, false, nil
+	}
+	if end == 0 {
+		return true, true, nil
+	}
+	cd, err := fc.compress(d[:end])
+	if err != nil {
+		return false, false, err
+	}
+	if err = fc.writeFile(cp, cd); err != nil {
+		return false, false, err
+	}
+	// 更新時間は変えない
+	os.Chtimes(cp, time.Unix(ust.Atime, 0).UTC(), time.Unix(ust.Mtime, 0).UTC())
+	fc.memoSize(cp, int64(end), true)
+	return true, false, nil
+}
+
+// 最後のメンバーだけを展開して、改行で終わっているか調べる
+func (fc *FileCache) lastMemberEndsLine(cs CompressedSizer, fp *os.File, size int64) bool {
+	off, err := cs.LastMember(fp, size)
+	if err != nil {
+		return false
+	}
+	d, err := fc.decompress(io.NewSectionReader(fp, off, size-off))
+	return err == nil && len(d) > 0 && d[len(d)-1] == '\n'
+}
+
+func (fc *FileCache) statCompressed(cp string) (CacheState, error) {
+	st, err := unlib.Stat(cp)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(cp)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	fst, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, err := fc.sizeOf(cp, fp, fst)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		fsize: size,
+		atime: st.Atime,
+		mtime: st.Mtime,
+	}, nil
+}
+
+// 展開後のサイズ
+// 記録が無く、展開せずに求められない場合は展開して数える
+func (fc *FileCache) sizeOf(cp string, fp *os.File, st os.FileInfo) (int64, error) {
+	if m, ok := fc.getMemo(cp); ok && m.csize == st.Size() && m.mtime == st.ModTime().UnixNano() {
+		return m.size, nil
+	}
+	if cs, ok := fc.Compress.(CompressedSizer); ok {
+		if n, err := cs.UncompressedSize(fp, st.Size()); err == nil {
+			fc.setMemo(cp, sizeMemo{csize: st.Size(), mtime: st.ModTime().UnixNano(), size: n})
+			return n, nil
+		}
+	}
+	zr, err := fc.Compress.NewReader(io.NewSectionReader(fp, 0, st.Size()))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(ioutil.Discard, zr)
+	if c, ok := zr.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		// 壊れている場合は読めたところまでの大きさ
+		return n, nil
+	}
+	fc.setMemo(cp, sizeMemo{csize: st.Size(), mtime: st.ModTime().UnixNano(), size: n})
+	return n, nil
+}
+
+// 書き込んだ直後のファイルの状態で記録する
+func (fc *FileCache) memoSize(cp string, size int64, lf bool) {
+	if st, err := os.Stat(cp); err == nil {
+		fc.setMemo(cp, sizeMemo{csize: st.Size(), mtime: st.ModTime().UnixNano(), size: size, lf: lf})
+	}
+}
+
+// 更新時間を変えた後も記録を使えるようにする
+func (fc *FileCache) touchMemo(cp string, csize, mtime int64) {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	if m, ok := fc.sizes[cp]; ok {
+		if st, err := os.Stat(cp); err == nil && m.csize == csize && m.mtime == mtime && st.Size() == csize {
+			m.mtime = st.ModTime().UnixNano()
+			fc.sizes[cp] = m
+		}
+	}
+}
+
+func (fc *FileCache) getMemo(cp string) (sizeMemo, bool) {
+	fc.mux.Lock()
+	m, ok := fc.sizes[cp]
+	fc.mux.Unlock()
+	return m, ok
+}
+
+func (fc *FileCache) setMemo(cp string, m sizeMemo) {
+	fc.mux.Lock()
+	if fc.sizes == nil || len(fc.sizes) >= SIZE_MEMO_MAX {
+		// 溢れた場合は作り直す
+		fc.sizes = make(map[string]sizeMemo, 1024)
+	}
+	fc.sizes[cp] = m
+	fc.mux.Unlock()
+}
@@ -0,0 +1,176 @@
+package get2ch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const (
+	testServer = "news.2ch.net"
+	testBoard  = "news"
+	testThread = "1234567890"
+)
+
+// 展開した回数を数える
+type countingGzip struct {
+	GzipCompressor
+	reads *int
+}
+
+func (cg countingGzip) NewReader(r io.Reader) (io.Reader, error) {
+	*cg.reads++
+	return cg.GzipCompressor.NewReader(r)
+}
+
+func newCompressedCache(t *testing.T) *FileCache {
+	fc := NewFileCache(t.TempDir())
+	fc.Compress = GzipCompressor{}
+	return fc
+}
+
+// 記録を消しても展開せずにサイズを求められる
+func TestCompressedSize(t *testing.T) {
+	fc := newCompressedCache(t)
+	var reads int
+	fc.Compress = countingGzip{reads: &reads}
+	want := []byte("1\n")
+	if err := fc.SetData(testServer, testBoard, testThread, want); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"2\n", "3\n", "4\n"} {
+		fc.sizes = nil
+		if err := fc.SetDataAppend(testServer, testBoard, testThread, []byte(d)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, d...)
+	}
+	fc.sizes = nil
+	st, err := fc.Stat(testServer, testBoard, testThread)
+	if err != nil || st.Size() != int64(len(want)) {
+		t.Fatalf("Stat() = %v, %v, want size %d", st, err, len(want))
+	}
+	if reads != 0 {
+		t.Errorf("decompressed %d times", reads)
+	}
+	if d, err := fc.GetData(testServer, testBoard, testThread); err != nil || !bytes.Equal(d, want) {
+		t.Errorf("GetData() = %q, %v", d, err)
+	}
+
+	cp := fc.Path(testServer, testBoard, testThread) + ".gz"
+	fp, err := os.Open(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	fst, _ := fp.Stat()
+	if n, err := (GzipCompressor{}).UncompressedSize(fp, fst.Size()); err != nil || n != int64(len(want)) {
+		t.Errorf("UncompressedSize() = %d, %v", n, err)
+	}
+	// 途中で切れたメンバーがある場合は求められない
+	if _, err := (GzipCompressor{}).UncompressedSize(fp, fst.Size()-1); err == nil {
+		t.Error("UncompressedSize() of truncated file succeeded")
+	}
+}
+
+// 長さを記録していないgzipも読め、修復時に書き直す
+func TestCompressedLegacy(t *testing.T) {
+	fc := newCompressedCache(t)
+	logfile := fc.Path(testServer, testBoard, testThread)
+	os.MkdirAll(logfile[:len(logfile)-len(testThread)-len(".dat")], 0777)
+	var buf bytes.Buffer
+	for _, d := range []string{"1\n", "2\n"} {
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(d))
+		zw.Close()
+	}
+	cp := logfile + ".gz"
+	if err := ioutil.WriteFile(cp, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fc.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000)
+	if st, err := fc.Stat(testServer, testBoard, testThread); err != nil || st.Size() != 4 {
+		t.Fatalf("Stat() = %v, %v", st, err)
+	}
+	fc.sizes = nil
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	fp, err := os.Open(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	fst, _ := fp.Stat()
+	if n, err := (GzipCompressor{}).UncompressedSize(fp, fst.Size()); err != nil || n != 4 {
+		t.Errorf("UncompressedSize() after Repair = %d, %v", n, err)
+	}
+	if st, _ := fc.Stat(testServer, testBoard, testThread); st.Mmod() != 1400000000 {
+		t.Errorf("Mmod() = %d", st.Mmod())
+	}
+}
+
+// 追記の途中で止まったメンバーを取り除く
+func TestCompressedRepair(t *testing.T) {
+	fc := newCompressedCache(t)
+	fc.SetData(testServer, testBoard, testThread, []byte("1\n2\n"))
+	cp := fc.Path(testServer, testBoard, testThread) + ".gz"
+	part, err := fc.compress([]byte("3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.OpenFile(cp, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Write(part[:tGZIP_SIZE_OFFSET+4])
+	fp.Close()
+	fc.sizes = nil
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if d, err := fc.GetData(testServer, testBoard, testThread); err != nil || string(d) != "1\n2\n" {
+		t.Errorf("GetData() = %q, %v", d, err)
+	}
+	if st, err := fc.Stat(testServer, testBoard, testThread); err != nil || st.Size() != 4 {
+		t.Errorf("Stat() = %v, %v", st, err)
+	}
+}
+
+// 全てのメンバーが揃っていても、最後のメンバーが改行で終わらない場合は取り除く
+func TestCompressedRepairLastLine(t *testing.T) {
+	fc := newCompressedCache(t)
+	fc.SetData(testServer, testBoard, testThread, []byte("1\n2\n"))
+	fc.SetDataAppend(testServer, testBoard, testThread, []byte("3"))
+	fc.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000)
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if d, err := fc.GetData(testServer, testBoard, testThread); err != nil || string(d) != "1\n2\n" {
+		t.Errorf("GetData() = %q, %v", d, err)
+	}
+	if st, _ := fc.Stat(testServer, testBoard, testThread); st.Mmod() != 1400000000 {
+		t.Errorf("Mmod() = %d", st.Mmod())
+	}
+	// 記録が無くても同じ
+	fc.SetDataAppend(testServer, testBoard, testThread, []byte("3"))
+	fc.sizes = nil
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || !repaired {
+		t.Fatalf("Repair() without memo = %v, %v", repaired, err)
+	}
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || repaired {
+		t.Errorf("Repair() again = %v, %v", repaired, err)
+	}
+
+	// 完全な行が無い場合は消す
+	fc.SetData(testServer, testBoard, testThread, []byte("1<>2"))
+	if repaired, err := fc.Repair(testServer, testBoard, testThread); err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if fc.Exists(testServer, testBoard, testThread) {
+		t.Error("Exists() = true after Repair")
+	}
+}
@@ -0,0 +1,70 @@
+package get2ch
+
+import (
+	"errors"
+	"github.com/tanaton/get2ch-go/internal/codec"
+	"strconv"
+)
+
+// errors.Isで判定できるエラー
+var (
+	ErrNotStarted  = errors.New("初期化されていません。")
+	ErrDatDropped  = errors.New("アクセス不可(dat落ち)")
+	ErrDatBroken   = errors.New("壊れているため表示できません。")
+	ErrBoardMoved  = errors.New("２ちゃんねるにアクセスできなかったので、サーバー移転チェックを行いました。")
+	ErrBourbon     = errors.New("バーボン中のため取得できませんでした。")
+	ErrNotModified = errors.New("更新されていません")
+	ErrInvalidKey  = errors.New("スレッドキーが不正です")
+	ErrTruncated   = errors.New("サイズ上限を超えたため途中までしか取得できませんでした。")
+	ErrNotBoard    = errors.New("板が指定されていません")
+	ErrNotThread   = errors.New("スレッドが指定されていません")
+	ErrMenuVersion = errors.New("板一覧の形式が対応していません")
+	ErrCodec       = codec.ErrInvalid
+)
+
+var errNilData = errors.New("data nil")
+
+// 取得失敗時の詳細
+// errors.Asで取り出して使う
+type HTTPStatusError struct {
+	Code    int // HTTPステータスコード
+	Server  string
+	Board   string
+	Thread  string
+	Bourbon bool  // バーボン中だった場合true
+	Err     error // 原因 ErrDatDropped等
+}
+
+func (e *HTTPStatusError) Error() string {
+	msg := "http status " + strconv.Itoa(e.Code)
+	if e.Err != nil {
+		msg = e.Err.Error() + " (" + msg + ")"
+	}
+	if e.Server != "" || e.Board != "" {
+		msg += " " + e.Server + "/" + e.Board
+		if e.Thread != "" {
+			msg += "/" + e.Thread
+		}
+	}
+	return msg
+}
+
+func (e *HTTPStatusError) Unwrap() error {
+	return e.Err
+}
+
+// バーボン中のエラーはErrBourbonとしても判定できる
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrBourbon && e.Bourbon
+}
+
+func (g2ch *Get2ch) statusError(err error) error {
+	return &HTTPStatusError{
+		Code:    g2ch.code,
+		Server:  g2ch.server,
+		Board:   g2ch.board,
+		Thread:  g2ch.thread,
+		Bourbon: g2ch.bourbon,
+		Err:     err,
+	}
+}
@@ -2,12 +2,10 @@ package main
 
 import (
 	"../"
-	"bufio"
-	"bytes"
+	"../parser"
+	"context"
 	"log"
 	"os"
-	"regexp"
-	"strconv"
 	"time"
 )
 
@@ -19,13 +17,12 @@ type Nich struct {
 
 const (
 	GO_THREAD_SLEEP_TIME = 2 * time.Second
-	GO_BOARD_SLEEP_TIME  = 4 * time.Second
+	GO_REQUEST_PER_SEC   = 0.5 // 鯖毎の取得間隔
+	GO_REQUEST_BURST     = 2
 )
 
-var g_reg_bbs = regexp.MustCompile(`(.+\.2ch\.net|.+\.bbspink\.com)/(.+)<>`)
-var g_reg_dat = regexp.MustCompile(`^([0-9]{9,10})\.dat<>`)
-var g_reg_res = regexp.MustCompile(` \(([0-9]{1,4})\)$`)
 var g_cache = get2ch.NewFileCache(`/2ch/dat`)
+var g_client *get2ch.Client
 var gLogger = log.New(os.Stdout, "", log.LstdFlags)
 var g_filter map[string]struct{} = map[string]struct{}{
 	"epg.2ch.net":          struct{}{},
@@ -36,11 +33,19 @@ var g_filter map[string]struct{} = map[string]struct{}{
 
 func main() {
 	// get2ch開始
-	get2ch.Start(g_cache, nil)
+	// 鯖への負荷はget2ch側で制限する
+	g_client = get2ch.NewClient(get2ch.Options{
+		Cache: g_cache,
+		RateLimit: get2ch.RateLimit{
+			RequestsPerSecond: GO_REQUEST_PER_SEC,
+			Burst:             GO_REQUEST_BURST,
+			MaxConns:          1,
+		},
+	})
 	nsl := getServer()
 	sl := nsl
 	// クローラーの立ち上げ
-	killch := startCrawler(sl)
+	cancel := startCrawler(sl)
 
 	tick := time.Tick(time.Minute * 10)
 	for _ = range tick {
@@ -64,82 +69,66 @@ func main() {
 
 		if flag {
 			// 今のクローラーを殺す
-			close(killch)
+			// 通信中のリクエストも中断される
+			cancel()
 			// 鯖を更新
 			sl = nsl
 			// 新クローラーの立ち上げ
-			killch = startCrawler(sl)
+			cancel = startCrawler(sl)
 		}
 	}
 }
 
-func startCrawler(sl map[string][]Nich) (killch chan struct{}) {
+func startCrawler(sl map[string][]Nich) context.CancelFunc {
 	// 新クローラー立ち上げ
-	killch = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	for key, it := range sl {
 		gLogger.Printf("Server:%s, Board_len:%d\n", key, len(it))
-		go mainThread(key, it, killch)
+		go mainThread(ctx, key, it)
 		time.Sleep(GO_THREAD_SLEEP_TIME)
 	}
-	return
+	return cancel
 }
 
-func checkOpen(ch <-chan struct{}) bool {
-	select {
-	case <-ch:
-		// chanがクローズされると即座にゼロ値が返ることを利用
-		return false
-	default:
-		break
-	}
-	return true
+func checkOpen(ctx context.Context) bool {
+	return ctx.Err() == nil
 }
 
-func mainThread(key string, bl []Nich, killch chan struct{}) {
+func mainThread(ctx context.Context, key string, bl []Nich) {
 	for {
 		for _, nich := range bl {
 			// 板の取得
-			tl := getBoard(nich)
+			tl := getBoard(ctx, nich)
 			if tl != nil && len(tl) > 0 {
 				// スレッドの取得
-				getThread(tl, nich.board, killch)
+				getThread(ctx, tl, nich.board)
 			}
-			if checkOpen(killch) == false {
+			if checkOpen(ctx) == false {
 				// 緊急停止
 				break
 			}
-			// 少し待機
-			time.Sleep(GO_BOARD_SLEEP_TIME)
 		}
-		if checkOpen(killch) == false {
+		if checkOpen(ctx) == false {
 			// 緊急停止
 			break
 		}
-		// 少し待機
-		time.Sleep(GO_BOARD_SLEEP_TIME)
 	}
 }
 
 func getServer() map[string][]Nich {
 	var nich Nich
-	get, _ := get2ch.NewGet2ch("", "")
 	sl := make(map[string][]Nich, 16)
-	// 更新時間を取得しない
-	data := get.GetBBSmenu(false)
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		if d := g_reg_bbs.FindStringSubmatch(scanner.Text()); len(d) > 0 {
-			nich.server = d[1]
-			nich.board = d[2]
-			if _, ok := g_filter[nich.server]; ok {
-				continue
-			}
-			nl, ok := sl[nich.server]
-			if !ok {
-				nl = make([]Nich, 0, 32)
-			}
-			sl[nich.server] = append(nl, nich)
+	for _, b := range g_client.ListBoards("") {
+		nich.server = b.Server
+		nich.board = b.Board
+		if _, ok := g_filter[nich.server]; ok {
+			continue
+		}
+		nl, ok := sl[nich.server]
+		if !ok {
+			nl = make([]Nich, 0, 32)
 		}
+		sl[nich.server] = append(nl, nich)
 	}
 	// 余分な領域を削る
 	for board, it := range sl {
@@ -149,10 +138,10 @@ func getServer() map[string][]Nich {
 	return sl
 }
 
-func getBoard(nich Nich) []Nich {
+func getBoard(ctx context.Context, nich Nich) []Nich {
 	h := threadResList(nich)
-	get, _ := get2ch.NewGet2ch(nich.board, "")
-	data, err := get.GetData()
+	get, _ := g_client.NewGet2ch(nich.board, "")
+	tl, err := get.GetThreadListContext(ctx)
 	if err != nil {
 		gLogger.Printf(err.Error() + "\n")
 		return nil
@@ -165,22 +154,12 @@ func getBoard(nich Nich) []Nich {
 
 	var n Nich
 	vect := make([]Nich, 0, 32)
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		it := scanner.Text()
-		if da := g_reg_dat.FindStringSubmatch(it); da != nil {
-			if d := g_reg_res.FindStringSubmatch(it); d != nil {
-				n.server = nich.server
-				n.board = nich.board
-				n.thread = da[1]
-				if m, ok := h[da[1]]; ok {
-					if j, err := strconv.Atoi(d[1]); err == nil && m != j {
-						vect = append(vect, n)
-					}
-				} else {
-					vect = append(vect, n)
-				}
-			}
+	for _, it := range tl {
+		n.server = nich.server
+		n.board = nich.board
+		n.thread = it.Key
+		if m, ok := h[it.Key]; !ok || m != it.Res {
+			vect = append(vect, n)
 		}
 	}
 	l := len(vect)
@@ -193,31 +172,23 @@ func threadResList(nich Nich) map[string]int {
 	if err != nil {
 		return h
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		it := scanner.Text()
-		if da := g_reg_dat.FindStringSubmatch(it); da != nil {
-			if d := g_reg_res.FindStringSubmatch(it); d != nil {
-				m, _ := strconv.Atoi(d[1])
-				h[da[1]] = m
-			}
-		}
+	for _, it := range parser.ParseSubject(data, parser.ENC_SJIS) {
+		h[it.Key] = it.Res
 	}
 	return h
 }
 
-func getThread(tl []Nich, board string, killch chan struct{}) {
+func getThread(ctx context.Context, tl []Nich, board string) {
 	for _, nich := range tl {
-		get, _ := get2ch.NewGet2ch(nich.board, nich.thread)
-		_, err := get.GetData()
+		get, _ := g_client.NewGet2ch(nich.board, nich.thread)
+		res, err := get.FetchContext(ctx)
 		if err != nil {
 			gLogger.Println(err)
 			gLogger.Printf("%s/%s/%s\n", nich.server, nich.board, nich.thread)
 		} else {
-			code := get.GetHttpCode()
-			gLogger.Printf("%d %s/%s/%s\n", code, nich.server, nich.board, nich.thread)
+			gLogger.Printf("%d %s %s/%s/%s\n", res.Code, res.Source, nich.server, nich.board, nich.thread)
 		}
-		if checkOpen(killch) == false {
+		if checkOpen(ctx) == false {
 			// 緊急停止
 			break
 		}
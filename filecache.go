@@ -1,10 +1,16 @@
 package get2ch
 
 import (
+	"bytes"
+	"context"
 	"github.com/tanaton/get2ch-go/unlib"
+	"hash/fnv"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -13,6 +19,12 @@ const (
 	tBOARD_LIST_NAME    = "ita.data"    // 板情報格納ファイル
 	tBOARD_SUBJECT_NAME = "subject.txt" // スレッド一覧格納ファイル名
 	tBOARD_SETTING_NAME = "setting.txt" // 板情報格納ファイル名
+	tTEMP_SUFFIX        = ".tmp"        // 書き込み中の一時ファイル
+	tREPAIR_BLOCK       = 4096          // 最終行を探す際に読む単位
+	tLOCK_FOLDER        = ".lock"       // 取得処理の排他に使うファイルを置くフォルダ
+	tLOCK_MENU_NAME     = "ita"         // 板一覧の排他に使うファイル名
+	LOCK_FILES          = 256           // 板一覧以外の排他に使うファイルの数
+	LOCK_POLL_INTERVAL  = 50 * time.Millisecond
 )
 
 type State struct {
@@ -26,7 +38,11 @@ func (s *State) Amod() int64 { return s.atime }
 func (s *State) Mmod() int64 { return s.mtime }
 
 type FileCache struct {
-	Folder string // dat保管フォルダ名
+	Folder   string     // dat保管フォルダ名
+	Sync     bool       // trueの場合は書き込み毎にディスクへ書き出す
+	Compress Compressor // nil以外の場合は圧縮して保存する 圧縮していないファイルも読める
+	sizes    map[string]sizeMemo
+	mux      sync.Mutex
 }
 
 func NewFileCache(root string) *FileCache {
@@ -36,50 +52,254 @@ func NewFileCache(root string) *FileCache {
 }
 
 func (fc *FileCache) Path(s, b, t string) string {
+	return cachePath(fc.Folder, s, b, t)
+}
+
+func cachePath(root, s, b, t string) string {
 	if s == "" && b == "" && t == "" {
-		return fc.Folder + "/" + tBOARD_LIST_NAME
+		return root + "/" + tBOARD_LIST_NAME
 	} else if t == BOARD_SETTING {
-		return fc.Folder + "/" + b + "/" + tBOARD_SETTING_NAME
+		return root + "/" + b + "/" + tBOARD_SETTING_NAME
 	} else if t == "" {
-		return fc.Folder + "/" + b + "/" + tBOARD_SUBJECT_NAME
+		return root + "/" + b + "/" + tBOARD_SUBJECT_NAME
 	}
-	return fc.Folder + "/" + b + "/" + t[0:4] + "/" + t + ".dat"
+	return root + "/" + b + "/" + t[0:4] + "/" + t + ".dat"
+}
+
+// スレッドキーはフォルダ分けに先頭4文字を使う
+func validKey(t string) bool {
+	return t == "" || t == BOARD_SETTING || len(t) >= 4
 }
 
 func (fc *FileCache) GetData(s, b, t string) ([]byte, error) {
+	if !validKey(t) {
+		return nil, ErrInvalidKey
+	}
 	logfile := fc.Path(s, b, t)
-	return ioutil.ReadFile(logfile)
+	if cp, ok := fc.compressedPath(logfile); ok {
+		return fc.getCompressed(cp)
+	}
+	fp, err := os.Open(logfile)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	// 追記中のデータを読まない
+	if err = unlib.LockFile(fp, false); err != nil {
+		return nil, err
+	}
+	defer unlib.UnlockFile(fp)
+	return ioutil.ReadAll(fp)
 }
 
 func (fc *FileCache) SetData(s, b, t string, d []byte) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
 	logfile := fc.Path(s, b, t)
+	if fc.Compress != nil {
+		return fc.setCompressed(logfile, d)
+	}
 	os.MkdirAll(path.Dir(logfile), 0666)
-	return ioutil.WriteFile(logfile, d, 0666)
+	return fc.writeFile(logfile, d)
+}
+
+// 一時ファイルに書いてから置き換える
+// 途中で止まっても元のファイルは壊れない
+func (fc *FileCache) writeFile(logfile string, d []byte) error {
+	var fp *os.File
+	var tmp string
+	var err error
+	for i := 0; i < 10; i++ {
+		tmp = logfile + "." + strconv.FormatUint(uint64(rand.Int63()), 36) + tTEMP_SUFFIX
+		fp, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(d)
+	if err == nil && fc.Sync {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, logfile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if fc.Sync {
+		syncDir(path.Dir(logfile))
+	}
+	return nil
+}
+
+// 置き換えたことをディスクへ書き出す
+// ディレクトリを同期できない環境では何もしない
+func syncDir(dir string) {
+	if fp, err := os.Open(dir); err == nil {
+		fp.Sync()
+		fp.Close()
+	}
 }
 
 func (fc *FileCache) SetDataAppend(s, b, t string, d []byte) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
 	logfile := fc.Path(s, b, t)
+	if fc.Compress != nil {
+		return fc.appendCompressed(logfile, d)
+	}
 	fp, err := os.OpenFile(logfile, os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
-	_, err = fp.Write(d)
-	fp.Close()
+	defer fp.Close()
+	if err = unlib.LockFile(fp, true); err != nil {
+		return err
+	}
+	defer unlib.UnlockFile(fp)
+	st, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = fp.Write(d); err == nil && fc.Sync {
+		err = fp.Sync()
+	}
+	if err != nil {
+		// 書きかけの部分を取り除く
+		fp.Truncate(st.Size())
+	}
 	return err
 }
 
+// 追記の途中で止まった場合に残る、改行で終わらない最終行を取り除く
+// datのみ対象で、取り除いた場合はtrueを返す
+// 更新時間は変えない 完全な行が残らない場合はdatを消す
+func (fc *FileCache) Repair(s, b, t string) (bool, error) {
+	if !validKey(t) {
+		return false, ErrInvalidKey
+	}
+	if t == "" || t == BOARD_SETTING {
+		return false, nil
+	}
+	logfile := fc.Path(s, b, t)
+	var repaired, empty bool
+	var err error
+	if cp, ok := fc.compressedPath(logfile); ok {
+		logfile = cp
+		repaired, empty, err = fc.repairCompressed(cp)
+	} else {
+		repaired, empty, err = fc.repairFile(logfile)
+	}
+	if err == nil && empty {
+		// 完全な行が無い場合は消して次回は全体を取得させる
+		// 空のまま更新時間を残すと304で空のdatを使い続けてしまう
+		err = os.Remove(logfile)
+	}
+	return repaired, err
+}
+
+// 最終行の途中を切り詰める
+// 完全な行が1行も無い場合は触らずにemptyを返す
+func (fc *FileCache) repairFile(logfile string) (repaired, empty bool, err error) {
+	fp, err := os.OpenFile(logfile, os.O_RDWR, 0666)
+	if err != nil {
+		return false, false, err
+	}
+	defer fp.Close()
+	if err = unlib.LockFile(fp, true); err != nil {
+		return false, false, err
+	}
+	defer unlib.UnlockFile(fp)
+	st, err := unlib.Stat(logfile)
+	if err != nil {
+		return false, false, err
+	}
+	end, err := lastLineEnd(fp, st.Size)
+	if err != nil || end == st.Size {
+		return false, false, err
+	}
+	if end == 0 {
+		return true, true, nil
+	}
+	if err = fp.Truncate(end); err == nil && fc.Sync {
+		err = fp.Sync()
+	}
+	if err != nil {
+		return false, false, err
+	}
+	os.Chtimes(logfile, time.Unix(st.Atime, 0).UTC(), time.Unix(st.Mtime, 0).UTC())
+	return true, false, nil
+}
+
+// 最後の改行の直後の位置
+// 改行が無い場合は0
+func lastLineEnd(fp *os.File, size int64) (int64, error) {
+	buf := make([]byte, tREPAIR_BLOCK)
+	for end := size; end > 0; {
+		start := end - tREPAIR_BLOCK
+		if start < 0 {
+			start = 0
+		}
+		n, err := fp.ReadAt(buf[:end-start], start)
+		if err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
 func (fc *FileCache) SetMod(s, b, t string, m, a int64) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
+	logfile := fc.Path(s, b, t)
+	if cp, ok := fc.compressedPath(logfile); ok {
+		before, berr := os.Stat(cp)
+		err := os.Chtimes(cp, time.Unix(a, 0).UTC(), time.Unix(m, 0).UTC())
+		if err == nil && berr == nil {
+			fc.touchMemo(cp, before.Size(), before.ModTime().UnixNano())
+		}
+		return err
+	}
 	// atimeとmtimeの順番に注意
-	return os.Chtimes(fc.Path(s, b, t), time.Unix(a, 0).UTC(), time.Unix(m, 0).UTC())
+	return os.Chtimes(logfile, time.Unix(a, 0).UTC(), time.Unix(m, 0).UTC())
 }
 
 func (fc *FileCache) Exists(s, b, t string) bool {
-	_, err := os.Stat(fc.Path(s, b, t))
+	if !validKey(t) {
+		return false
+	}
+	logfile := fc.Path(s, b, t)
+	if _, ok := fc.compressedPath(logfile); ok {
+		return true
+	}
+	_, err := os.Stat(logfile)
 	return err == nil
 }
 
 func (fc *FileCache) Stat(s, b, t string) (CacheState, error) {
-	st, err := unlib.Stat(fc.Path(s, b, t))
+	if !validKey(t) {
+		return nil, ErrInvalidKey
+	}
+	logfile := fc.Path(s, b, t)
+	if cp, ok := fc.compressedPath(logfile); ok {
+		// 差分取得の位置に使うため展開後のサイズを返す
+		return fc.statCompressed(cp)
+	}
+	st, err := unlib.Stat(logfile)
 	if err != nil {
 		return nil, err
 	}
@@ -89,3 +309,55 @@ func (fc *FileCache) Stat(s, b, t string) (CacheState, error) {
 		mtime: st.Mtime,
 	}, nil
 }
+
+// 排他に使うファイル
+// datの数だけファイルを作らないよう、パスのハッシュでLOCK_FILES個に振り分ける
+// スレッドの取得中に板一覧を取得することがあるため、板一覧は別のファイルにする
+func (fc *FileCache) lockPath(s, b, t string) string {
+	dir := fc.Folder + "/" + tLOCK_FOLDER + "/"
+	if s == "" && b == "" && t == "" {
+		return dir + tLOCK_MENU_NAME
+	}
+	h := fnv.New32a()
+	h.Write([]byte(fc.Path(s, b, t)))
+	return dir + strconv.FormatUint(uint64(h.Sum32()%LOCK_FILES), 16)
+}
+
+// 取得処理全体の排他
+// 同じフォルダを使う別のプロセスとも排他する
+// 排他用のファイルは消さずに残す(LOCK_FILES+1個まで)
+// 別のデータでも同じファイルを使う場合があり、その場合は片方が待つ
+func (fc *FileCache) Lock(ctx context.Context, s, b, t string) (func(), error) {
+	if !validKey(t) {
+		return nil, ErrInvalidKey
+	}
+	lockfile := fc.lockPath(s, b, t)
+	// 他のユーザーのプロセスも排他に使えるよう実行権限を付ける
+	os.MkdirAll(path.Dir(lockfile), 0777)
+	fp, err := os.OpenFile(lockfile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := unlib.TryLockFile(fp, true)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		if ok {
+			return func() {
+				unlib.UnlockFile(fp)
+				fp.Close()
+			}, nil
+		}
+		// 待っている間も中断できるよう少しずつ待つ
+		timer := time.NewTimer(LOCK_POLL_INTERVAL)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			fp.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package get2ch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileCache(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	const s, b, th = testServer, testBoard, testThread
+	if fc.Exists(s, b, th) {
+		t.Fatal("Exists() = true before SetData")
+	}
+	if err := fc.SetData(s, b, th, []byte("1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.SetDataAppend(s, b, th, []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := fc.GetData(s, b, th); err != nil || string(d) != "1\n2\n" {
+		t.Errorf("GetData() = %q, %v", d, err)
+	}
+	if err := fc.SetMod(s, b, th, 1400000000, 1400000001); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := fc.Stat(s, b, th); err != nil || st.Size() != 4 || st.Mmod() != 1400000000 {
+		t.Errorf("Stat() = %v, %v", st, err)
+	}
+	if err := fc.SetDataAppend(s, b, "9999999999", []byte("1\n")); !os.IsNotExist(err) {
+		t.Errorf("SetDataAppend() to missing dat = %v", err)
+	}
+	if _, err := fc.GetData(s, b, "1"); err != ErrInvalidKey {
+		t.Errorf("GetData() with short key = %v", err)
+	}
+}
+
+// 改行で終わらない最終行を取り除き、更新時間は変えない
+func TestFileCacheRepair(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	const s, b, th = testServer, testBoard, testThread
+	fc.SetData(s, b, th, []byte("1\n2\n3"))
+	fc.SetMod(s, b, th, 1400000000, 1400000000)
+	repaired, err := fc.Repair(s, b, th)
+	if err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if d, _ := fc.GetData(s, b, th); string(d) != "1\n2\n" {
+		t.Errorf("GetData() = %q", d)
+	}
+	if st, _ := fc.Stat(s, b, th); st.Mmod() != 1400000000 {
+		t.Errorf("Mmod() = %d", st.Mmod())
+	}
+	if repaired, err := fc.Repair(s, b, th); err != nil || repaired {
+		t.Errorf("Repair() again = %v, %v", repaired, err)
+	}
+}
+
+// 改行が1つも無い場合は空にせず消して、次回は全体を取得させる
+func TestFileCacheRepairNoLine(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	const s, b, th = testServer, testBoard, testThread
+	fc.SetData(s, b, th, []byte("1<>2"))
+	fc.SetMod(s, b, th, 1400000000, 1400000000)
+	if repaired, err := fc.Repair(s, b, th); err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if fc.Exists(s, b, th) {
+		t.Error("Exists() = true after Repair")
+	}
+	if _, err := fc.Stat(s, b, th); err == nil {
+		t.Error("Stat() err = nil after Repair")
+	}
+}
+
+// 修復で消した場合は更新確認をせずに取り直す
+func TestFetchRepairNoLine(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	c, ft := newFakeClient(t, fc, Options{}, func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-Modified-Since") != "" {
+			return fakeResponse(req, 304, ""), nil
+		}
+		return fakeResponse(req, 200, testDat1), nil
+	})
+	fc.SetData(testServer, testBoard, testThread, []byte("a<>sage"))
+	fc.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000)
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	res, err := g2ch.Fetch()
+	if err != nil || res.Code != 200 || string(res.Data) != testDat1 {
+		t.Fatalf("Fetch() = %d %q, %v", res.Code, res.Data, err)
+	}
+	if reqs := ft.requests(); len(reqs) != 1 || reqs[0].Header.Get("Range") != "" {
+		t.Errorf("requests = %v", reqs)
+	}
+}
+
+// 排他用のファイルはdatの数に関係なくLOCK_FILES+1個まで
+func TestFileCacheLockFiles(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	ctx := context.Background()
+	for i := 0; i < 2000; i++ {
+		unlock, err := fc.Lock(ctx, "news.2ch.net", "news", strconv.Itoa(1400000000+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlock()
+	}
+	list, err := ioutil.ReadDir(fc.Folder + "/" + tLOCK_FOLDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) > LOCK_FILES {
+		t.Errorf("%d lock files, want <= %d", len(list), LOCK_FILES)
+	}
+	if _, err := os.Stat(fc.Path("news.2ch.net", "news", "1400000000") + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file next to dat: %v", err)
+	}
+	// rootで実行しても分かるよう権限そのものを確かめる
+	st, err := os.Stat(fc.Folder + "/" + tLOCK_FOLDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm()&0700 != 0700 {
+		t.Errorf("lock folder mode = %v, want owner rwx", st.Mode().Perm())
+	}
+}
+
+// スレッドの取得中でも板一覧の排他を取れる
+func TestFileCacheLockMenu(t *testing.T) {
+	fc := NewFileCache(t.TempDir())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unlock, err := fc.Lock(ctx, "news.2ch.net", "news", "1400000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	munlock, err := fc.Lock(ctx, "", "", "")
+	if err != nil {
+		t.Fatalf("menu Lock() = %v", err)
+	}
+	munlock()
+
+	// 同じデータは待つ
+	wctx, wcancel := context.WithTimeout(context.Background(), 3*LOCK_POLL_INTERVAL)
+	defer wcancel()
+	if _, err := fc.Lock(wctx, "news.2ch.net", "news", "1400000000"); err != context.DeadlineExceeded {
+		t.Errorf("second Lock() = %v, want DeadlineExceeded", err)
+	}
+}
+
+// 短いスレッドキーはPathを作る前に弾く
+func TestLockFetchInvalidKey(t *testing.T) {
+	c := &Client{fetching: newKeyLock()}
+	if _, err := c.lockFetch(context.Background(), NewFileCache(t.TempDir()), "a.2ch.net", "news", "123"); err != ErrInvalidKey {
+		t.Errorf("lockFetch() = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestLockFetchKey(t *testing.T) {
+	c := &Client{fetching: newKeyLock()}
+	ctx := context.Background()
+	fc1 := NewFileCache(t.TempDir())
+	fc2 := NewFileCache(t.TempDir())
+	unlock, err := c.lockFetch(ctx, fc1, "a.2ch.net", "news", "1400000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	// 保存先やサーバが異なれば待たない
+	for _, it := range []struct {
+		cache Cache
+		s     string
+	}{
+		{fc2, "a.2ch.net"},
+		{NewMemoryCache(0, 0), "b.2ch.net"},
+	} {
+		wctx, cancel := context.WithTimeout(ctx, time.Second)
+		u, err := c.lockFetch(wctx, it.cache, it.s, "news", "1400000000")
+		cancel()
+		if err != nil {
+			t.Errorf("lockFetch(%s) = %v", it.s, err)
+			continue
+		}
+		u()
+	}
+}
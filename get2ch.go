@@ -1,16 +1,13 @@
 package get2ch
 
 import (
-	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"errors"
-	"fmt"
-	"github.com/tanaton/get2ch-go/process"
 	"github.com/tanaton/get2ch-go/unlib"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -53,25 +50,50 @@ type Cache interface {
 	Stat(s, b, t string) (CacheState, error)
 }
 
+// 書き込み途中で止まったデータを修復できるCache
+// 差分取得の前に呼び出す
+type CacheRepairer interface {
+	Repair(s, b, t string) (bool, error)
+}
+
+// 別のプロセスと共有できる排他機能を持つCache
+// 取得処理の間、同じデータへの取得と書き込みを止める
+type CacheLocker interface {
+	Lock(ctx context.Context, s, b, t string) (unlock func(), err error)
+}
+
 type Salami struct {
 	Host string
 	Port int
 }
 
 type Get2ch struct {
-	size      int64 // datのデータサイズ
-	mod       int64 // datの最終更新時間
-	cache_mod int64 // datの最終更新時間
-	code      int   // HTTPステータスコード
-	err       error // エラーメッセージ
-	server    string
-	board     string
-	thread    string
-	req_time  int64
-	cache     Cache
-	bourbon   bool // バーボンフラグ
-	numlines  int  // 行数
-	salami    string
+	size       int64 // datのデータサイズ
+	mod        int64 // datの最終更新時間
+	cache_mod  int64 // datの最終更新時間
+	code       int   // HTTPステータスコード
+	err        error // エラーメッセージ
+	server     string
+	board      string
+	thread     string
+	req_time   int64
+	cache      Cache
+	bourbon    bool // バーボンフラグ
+	numlines   int  // 行数
+	salami     string
+	user_agent string
+	client     *Client
+	source     Source // データの取得元
+	appended   bool   // 差分追記した場合true
+	append_at  int64  // 追記したデータの開始位置
+	abone      bool   // あぼーん検知
+	transfer   int64  // 転送したバイト数
+	truncated  bool   // サイズ上限で切り詰めた
+	attempts   int    // HTTPの試行回数
+	limits     Limits
+	timeouts   Timeouts
+	reqerr     error      // 通信エラー
+	mux        sync.Mutex // 取得結果の公開と設定の変更
 }
 
 var catekill = map[string]bool{
@@ -119,112 +141,125 @@ var hideboard = map[string]hideData{
 
 var RegServerItem = regexp.MustCompile(`<B>([^<]+)<\/B>`)
 var RegServer = regexp.MustCompile(`<A HREF=http:\/\/([^\/]+)\/([^\/]+)\/>([^<]+)<\/A>`)
-var g_once sync.Once
-var boardServerObj *process.BoardServerBox
-var boardNameObj *process.BoardNameBox
-var bbnCacheObj *process.BBNCacheBox
-var g_cache Cache
-var g_salami string
-var g_user_agent string
-var g_started bool
 var tanpanman = []byte{0x92, 0x5A, 0x83, 0x70, 0x83, 0x93, 0x83, 0x7d, 0x83, 0x93, 0x20, 0x81, 0x9a}
 var nagoyaee = []byte{0x96, 0xBC, 0x8C, 0xC3, 0x89, 0xAE, 0x82, 0xCD, 0x83, 0x47, 0x81, 0x60, 0x83, 0x47, 0x81, 0x60, 0x82, 0xC5}
 
-// get2ch管理機能の起動
-// 使用を開始する前に呼び出すこと
-func Start(c Cache, s *Salami) {
-	// サーバリスト更新
-	g_once.Do(func() {
-		SetCache(c)
-		SetSalami(s)
-		SetUserAgent(USER_AGENT)
-		boardServerObj = process.NewBoardServerBox(setServerList)
-		boardNameObj = process.NewBoardNameBox()
-		bbnCacheObj = process.NewBBNCacheBox()
-		g_started = true
-	})
-}
-
-func SetSalami(s *Salami) {
-	if s != nil {
-		g_salami = fmt.Sprintf("%s:%d/", s.Host, s.Port)
-	} else {
-		g_salami = ""
-	}
+func (g2ch *Get2ch) GetData() (data []byte, err error) {
+	return g2ch.GetDataContext(context.Background())
 }
 
-func SetCache(c Cache) {
-	if c != nil {
-		g_cache = c
+// ctxがキャンセルされた場合はctx.Err()を返す
+// 通信できなかった場合はキャッシュのデータと*HTTPStatusErrorを返す
+// タイムアウトの場合はerrors.Asで*TimeoutErrorを取り出せる
+func (g2ch *Get2ch) GetDataContext(ctx context.Context) (data []byte, err error) {
+	res, err := g2ch.FetchContext(ctx)
+	// SJIS-winで返す
+	return res.Data, err
+}
+
+// 1回の取得で使う作業用の複製
+// 取得中の状態を他の呼び出しと共有しないため、設定だけを写す
+func (g2ch *Get2ch) worker() *Get2ch {
+	g2ch.mux.Lock()
+	defer g2ch.mux.Unlock()
+	return &Get2ch{
+		server:     g2ch.server,
+		board:      g2ch.board,
+		thread:     g2ch.thread,
+		req_time:   g2ch.req_time,
+		cache:      g2ch.cache,
+		salami:     g2ch.salami,
+		user_agent: g2ch.user_agent,
+		client:     g2ch.client,
+		limits:     g2ch.limits,
+		timeouts:   g2ch.timeouts,
+		bourbon:    g2ch.bourbon, // SETTING.TXTの取得で使う
 	}
 }
 
-func SetUserAgent(ua string) {
-	g_user_agent = ua
+// 取得した結果をGetByteSize等で参照できるようにする
+func (g2ch *Get2ch) publish(w *Get2ch) {
+	g2ch.mux.Lock()
+	g2ch.size = w.size
+	g2ch.mod = w.mod
+	g2ch.cache_mod = w.cache_mod
+	g2ch.code = w.code
+	g2ch.err = w.err
+	g2ch.bourbon = w.bourbon
+	g2ch.numlines = w.numlines
+	g2ch.source = w.source
+	g2ch.appended = w.appended
+	g2ch.append_at = w.append_at
+	g2ch.abone = w.abone
+	g2ch.transfer = w.transfer
+	g2ch.truncated = w.truncated
+	g2ch.attempts = w.attempts
+	g2ch.reqerr = w.reqerr
+	g2ch.mux.Unlock()
 }
 
-func NewGet2ch(board, thread string) (*Get2ch, error) {
-	if g_started == false {
-		return nil, errors.New("初期化されていません。")
-	}
-	g2ch := &Get2ch{
-		size:      0,
-		mod:       0,
-		cache_mod: 0,
-		code:      0,
-		err:       nil,
-		server:    "",
-		board:     "",
-		thread:    "",
-		req_time:  time.Now().Unix(),
-		cache:     g_cache,
-		bourbon:   false, // バーボンフラグ
-		numlines:  0,
-		salami:    g_salami,
+// 排他した状態で取得し、結果をまとめる
+// 作業用の複製(worker)から呼び出す
+func (g2ch *Get2ch) fetch(ctx context.Context) (res FetchResult, err error) {
+	start := time.Now()
+	if err = ctx.Err(); err != nil {
+		g2ch.err = err
+		return
 	}
-	g2ch.server = g2ch.GetServer(board)
-	g2ch.board = board
-	if _, err := strconv.ParseInt(thread, 10, 64); err == nil {
-		g2ch.thread = thread
+	// 同じデータを同時に取得すると差分を重複して追記してしまう
+	unlock, err := g2ch.client.lockFetch(ctx, g2ch.cache, g2ch.server, g2ch.board, g2ch.thread)
+	if err != nil {
+		g2ch.err = err
+		return
 	}
-	return g2ch, nil
-}
-
-func (g2ch *Get2ch) GetData() (data []byte, err error) {
-	// 初期化
-	g2ch.size = 0
-	g2ch.mod = 0
-	g2ch.cache_mod = 0
-	g2ch.code = 0
-	g2ch.err = nil
+	defer unlock()
 	// 現在のバーボン状態を取得
 	g2ch.bourbon = g2ch.getBourbonCache()
-	g2ch.numlines = 0
 
 	// 通常取得
+	var data []byte
 	if g2ch.bourbon {
-		data = g2ch.bourbonData()
+		data = g2ch.bourbonData(ctx)
 	} else {
-		data = g2ch.normalData(true)
+		data = g2ch.normalData(ctx, true)
 	}
-	err = g2ch.err
-	// SJIS-winで返す
-	return
+	if cerr := ctx.Err(); cerr != nil {
+		// 中断された
+		g2ch.err = cerr
+		return res, cerr
+	}
+	if g2ch.err == nil && g2ch.reqerr != nil {
+		// キャッシュを返した場合も通信できなかったことは知らせる
+		g2ch.err = g2ch.statusError(g2ch.reqerr)
+	}
+	res, err = g2ch.result(data, start)
+	if g2ch.err == nil {
+		g2ch.err = err
+	}
+	return res, g2ch.err
 }
 
 func (g2ch *Get2ch) GetByteSize() int64 {
+	g2ch.mux.Lock()
+	defer g2ch.mux.Unlock()
 	return g2ch.size
 }
 
 func (g2ch *Get2ch) GetModified() int64 {
+	g2ch.mux.Lock()
+	defer g2ch.mux.Unlock()
 	return g2ch.mod
 }
 
 func (g2ch *Get2ch) GetHttpCode() int {
+	g2ch.mux.Lock()
+	defer g2ch.mux.Unlock()
 	return g2ch.code
 }
 
 func (g2ch *Get2ch) GetError() error {
+	g2ch.mux.Lock()
+	defer g2ch.mux.Unlock()
 	return g2ch.err
 }
 
@@ -243,27 +278,25 @@ func (g2ch *Get2ch) isBoard() bool {
 	return g2ch.server != "" && g2ch.board != "" && g2ch.thread == ""
 }
 
-func dialTimeout(network, addr string) (net.Conn, error) {
-	con, err := net.DialTimeout(network, addr, TIMEOUT_SEC)
-	if err == nil {
-		con.SetDeadline(time.Now().Add(TIMEOUT_SEC))
-	}
-	return con, err
+// 転送量を数える
+type countReader struct {
+	rc io.ReadCloser
+	n  int64
 }
 
-func newHttpClient() *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			Dial:                  dialTimeout,
-			DisableKeepAlives:     true,
-			DisableCompression:    true, // 圧縮解凍は全てこっちで指示する
-			ResponseHeaderTimeout: TIMEOUT_SEC,
-		},
-		CheckRedirect: unlib.RedirectPolicy,
-	}
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.n += int64(n)
+	return n, err
 }
 
-func responseRead(resp *http.Response) (data []byte, err error) {
+func (cr *countReader) Close() error {
+	return cr.rc.Close()
+}
+
+// limitを超えた場合は切り詰めてtruncatedを返す
+// limitが負の場合は全て読む
+func responseRead(resp *http.Response, limit int64) (data []byte, truncated bool, err error) {
 	var r io.Reader
 	var gz io.ReadCloser
 
@@ -276,32 +309,40 @@ func responseRead(resp *http.Response) (data []byte, err error) {
 		// 圧縮されていない場合
 		r = resp.Body
 	}
-	data, err = ioutil.ReadAll(io.LimitReader(r, DAT_MAX_SIZE))
+	if limit >= 0 {
+		// 1バイト多く読んで超えたか判定する
+		r = io.LimitReader(r, limit+1)
+	}
+	data, err = ioutil.ReadAll(r)
+	if limit >= 0 && int64(len(data)) > limit {
+		data = data[:limit]
+		truncated = true
+	}
 	if gz != nil {
 		gz.Close()
 	}
 	return
 }
 
-func getHttpBBSmenu(cache Cache) (data []byte, mod int64, err error) {
-	client := newHttpClient()
+func (c *Client) getHttpBBSmenu(ctx context.Context, cache Cache) (data []byte, mod int64, err error) {
 	// header生成
-	req, nrerr := http.NewRequest("GET", "http://"+g_salami+CONF_ITAURL_HOST+"/"+CONF_ITAURL_FILE, nil)
+	req, nrerr := http.NewRequestWithContext(ctx, "GET", "http://"+c.getSalami()+CONF_ITAURL_HOST+"/"+CONF_ITAURL_FILE, nil)
 	if nrerr != nil {
 		return nil, 0, nrerr
 	}
-	req.Header.Set("User-Agent", g_user_agent)
+	req.Header.Set("User-Agent", c.getUserAgent())
 	// 更新確認
 	if st, merr := cache.Stat("", "", ""); merr == nil {
 		req.Header.Set("If-Modified-Since", unlib.CreateModString(st.Mmod()))
 	}
 	req.Header.Set("Accept-Encoding", "gzip")
-	req.Header.Set("Connection", "close")
-	resp, doerr := client.Do(req)
-	if doerr != nil {
-		return nil, 0, doerr
+	c.setConnection(req)
+	ex := c.do(ctx, c.getSalami()+CONF_ITAURL_HOST, req, c.timeouts, c.limits.BBSmenu)
+	if ex.err != nil {
+		// 途中までの板一覧は使わない
+		return nil, 0, ex.err
 	}
-	defer resp.Body.Close()
+	resp := ex.resp
 
 	code := resp.StatusCode
 	if t, lerr := http.ParseTime(resp.Header.Get("Last-Modified")); lerr == nil {
@@ -311,68 +352,83 @@ func getHttpBBSmenu(cache Cache) (data []byte, mod int64, err error) {
 	}
 
 	if code == 200 {
-		// レスポンスボディをラップする
-		data, err = responseRead(resp)
+		data = ex.data
 	} else {
-		err = errors.New("更新されていません")
+		err = &HTTPStatusError{Code: code}
+		if code == 304 {
+			err = ErrNotModified
+		}
 	}
 	return
 }
 
 // 板一覧取得
-func saveBBSmenu(cache Cache) []byte {
-	d, mod, err := getHttpBBSmenu(cache)
+// 取得に成功した場合はClientの板一覧も更新する
+func (c *Client) saveBBSmenu(ctx context.Context, cache Cache) *Menu {
+	unlock, err := c.lockFetch(ctx, cache, "", "", "")
+	if err != nil {
+		return nil
+	}
+	defer unlock()
+	d, mod, err := c.getHttpBBSmenu(ctx, cache)
 	if err != nil {
 		// errがnil以外の時、rcはnil
 		return nil
 	}
-
-	// これ以降はUTF-8
-	data := bytes.Buffer{}
-	scanner := bufio.NewScanner(unlib.ShiftJISToUtf8Reader(bytes.NewReader(d)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if match := RegServerItem.FindStringSubmatch(line); match != nil {
-			// 当てはまるものを除外
-			if _, ok := catekill[match[1]]; !ok {
-				data.WriteString(match[1] + "\n")
-			}
-		} else if strings.Contains(line, ".2ch.net/") || strings.Contains(line, ".bbspink.com/") {
-			if strings.Contains(line, "TARGET") {
-				continue
-			}
-			if match := RegServer.FindStringSubmatch(line); match != nil {
-				server := match[1]
-				board := match[2]
-				title := match[3]
-				if _, ok := sabakill[server]; ok {
-					continue
-				}
-				data.WriteString(server + "/" + board + "<>" + title + "\n")
-			}
-		}
+	m := c.parseBBSmenu(d)
+	if ctx.Err() != nil {
+		// 中断された場合は書き込まない
+		return nil
+	}
+	data, err := m.encode()
+	if err != nil {
+		return nil
 	}
 	// ファイルにはUTF-8で保存
-	cache.SetData("", "", "", data.Bytes())
+	cache.SetData("", "", "", data)
 	cache.SetMod("", "", "", mod, mod)
-	return data.Bytes()
+	c.setMenu(m)
+	return m
 }
 
 func (g2ch *Get2ch) GetBBSmenu(flag bool) (data []byte) { // trueがデフォルト
+	data, _ = g2ch.GetBBSmenuContext(context.Background(), flag)
+	return
+}
+
+// 板一覧を「カテゴリ名」と「server/board<>板名」が並んだ形式(UTF-8)で返す
+// 保存形式に関係なく以前と同じ形式で返す 構造のまま使う場合はGetMenuContext
+func (g2ch *Get2ch) GetBBSmenuContext(ctx context.Context, flag bool) (data []byte, err error) {
+	m, err := g2ch.GetMenuContext(ctx, flag)
+	if m != nil {
+		data = m.encodeLegacy()
+	}
+	return
+}
+
+func (g2ch *Get2ch) GetMenu(flag bool) *Menu { // trueがデフォルト
+	m, _ := g2ch.GetMenuContext(context.Background(), flag)
+	return m
+}
+
+// 板一覧を返す
+// 保存されていない場合は取得する
+func (g2ch *Get2ch) GetMenuContext(ctx context.Context, flag bool) (m *Menu, err error) {
 	if g2ch.cache.Exists("", "", "") == false {
 		// 存在しない場合取得する
-		data = saveBBSmenu(g2ch.cache)
+		m = g2ch.client.saveBBSmenu(ctx, g2ch.cache)
+	} else {
+		// 以前の形式で保存されていてもJSONに書き換えて読む
+		m = g2ch.client.loadMenu(g2ch.cache)
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
 	}
 	if flag {
 		if st, err := g2ch.cache.Stat("", "", ""); err == nil {
+			g2ch.mux.Lock()
 			g2ch.mod = st.Mmod()
-		}
-	}
-	if data == nil {
-		var err error
-		data, err = g2ch.cache.GetData("", "", "")
-		if err != nil {
-			data = nil
+			g2ch.mux.Unlock()
 		}
 	}
 	return
@@ -383,87 +439,66 @@ func (g2ch *Get2ch) GetServer(board_key string) string {
 	if board_key == "" {
 		retdata = g2ch.server
 	} else {
-		retdata = boardServerObj.GetServer(board_key)
+		if b, ok := g2ch.client.LookupBoard(board_key); ok {
+			retdata = b.Server
+		}
 	}
 	return retdata
 }
 
-func setServerList() map[string]string {
-	m := make(map[string]string, 1024)
-	cache := g_cache
-	data := saveBBSmenu(cache)
-	if data == nil {
-		var err error
-		data, err = cache.GetData("", "", "")
-		if err != nil {
-			return m
-		}
-	}
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		sp := strings.Split(scanner.Text()+"<>", "<>")
-		dat, name := sp[0], sp[1]
-		if name != "" {
-			u := strings.Split(dat, "/")
-			server, board := u[0], u[1]
-			if _, ok := m[board]; !ok {
-				// 存在しなかったらセットする
-				m[board] = server
-			}
-		}
+// 板一覧を更新する
+// 定期的に呼び出される
+func (c *Client) refreshMenu(ctx context.Context) {
+	cache := c.getCache()
+	menu := c.saveBBSmenu(ctx, cache)
+	if menu == nil {
+		// 取得できなかった場合は保存済みの板一覧を使う
+		c.loadSavedMenu()
 	}
-	// 隠し板をロードする
-	for board, it := range hideboard {
-		if _, ok := m[board]; !ok {
-			m[board] = it.server
-		}
-	}
-	return m
 }
 
-func getBoardNameSub(bd string) string {
-	data, err := g_cache.GetData("", "", "")
-	if err != nil {
-		return ""
-	}
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		sp := strings.Split(scanner.Text()+"<>", "<>")
-		dat, name := sp[0], sp[1]
-		if name != "" {
-			u := strings.Split(dat, "/")
-			board := u[1]
-			if bd == board {
-				return name
-			}
-		}
+// 保存済みの板一覧を使う
+// 読めない場合は現在の板一覧のまま
+func (c *Client) loadSavedMenu() {
+	menu := c.loadMenu(c.getCache())
+	if menu == nil {
+		menu = c.Menu()
 	}
-	// 隠し板をロードする
-	for board, it := range hideboard {
-		if bd == board {
-			return it.name
-		}
+	if menu == nil {
+		menu = newMenu()
+		menu.build()
 	}
-	return ""
+	c.setMenu(menu)
 }
 
 // 板名取得
 func (g2ch *Get2ch) GetBoardName() (boardname string) {
+	boardname, _ = g2ch.GetBoardNameContext(context.Background())
+	return
+}
+
+func (g2ch *Get2ch) GetBoardNameContext(ctx context.Context) (boardname string, err error) {
 	// 板名マップの探索
-	boardname = boardNameObj.GetName(g2ch.board)
+	boardname = g2ch.client.boardName.GetName(g2ch.board)
 
 	if boardname == "" {
-		boardname = g2ch.sliceBoardName()
+		boardname = g2ch.sliceBoardName(ctx)
+		if err = ctx.Err(); err != nil {
+			// 中断された場合は登録しない
+			return "", err
+		}
 		if boardname == "" {
-			boardname = getBoardNameSub(g2ch.board)
+			if b, ok := g2ch.client.LookupBoard(g2ch.board); ok {
+				boardname = b.Name
+			}
 		}
 		// 空白でも登録
-		boardNameObj.SetName(g2ch.board, boardname)
+		g2ch.client.boardName.SetName(g2ch.board, boardname)
 	}
 	return
 }
 
-func (g2ch *Get2ch) getSettingFile() ([]byte, error) {
+func (g2ch *Get2ch) getSettingFile(ctx context.Context) ([]byte, error) {
 	server := g2ch.server
 	board := g2ch.board
 	req_time := g2ch.req_time
@@ -485,34 +520,37 @@ func (g2ch *Get2ch) getSettingFile() ([]byte, error) {
 		if err != nil {
 			cdata = []byte{}
 		}
-		return unlib.ShiftJISToUtf8(cdata), nil
+		return g2ch.client.codec.Decode(cdata)
 	}
 
-	client := newHttpClient()
 	// header生成
-	req, nrerr := http.NewRequest("GET", "http://"+g2ch.salami+server+"/"+board+"/"+FILE_SETTING_TXT_REQ, nil)
+	req, nrerr := http.NewRequestWithContext(ctx, "GET", "http://"+g2ch.salami+server+"/"+board+"/"+FILE_SETTING_TXT_REQ, nil)
 	if nrerr != nil {
 		return nil, nrerr
 	}
-	req.Header.Set("User-Agent", g_user_agent)
+	req.Header.Set("User-Agent", g2ch.user_agent)
 	req.Header.Set("Accept-Encoding", "gzip")
-	req.Header.Set("Connection", "close")
-	resp, doerr := client.Do(req)
-	if doerr != nil {
+	g2ch.client.setConnection(req)
+	ex := g2ch.client.do(ctx, g2ch.salami+server, req, g2ch.timeouts, g2ch.limits.Setting)
+	g2ch.attempts += ex.attempts
+	resp, data, doerr := ex.resp, ex.data, ex.err
+	if doerr != nil && !errors.Is(doerr, ErrTruncated) {
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
 		return nil, doerr
 	}
-	defer resp.Body.Close()
 
-	var data []byte
 	var err error
 	code := resp.StatusCode
-	if code == 200 {
-		// 読み込む
-		if data, err = responseRead(resp); err == nil {
-			g2ch.cache.SetData(server, board, BOARD_SETTING, data)
-			mod := req_time + (3600 * 24 * 7)
-			g2ch.cache.SetMod(server, board, BOARD_SETTING, mod, mod)
-		}
+	if doerr != nil {
+		// 切り詰めたデータは保存せず、エラーと共に返す
+		d, _ := g2ch.client.codec.Decode(data)
+		return d, doerr
+	} else if code == 200 {
+		g2ch.cache.SetData(server, board, BOARD_SETTING, data)
+		mod := req_time + (3600 * 24 * 7)
+		g2ch.cache.SetMod(server, board, BOARD_SETTING, mod, mod)
 	} else {
 		// 板名取得失敗
 		// 特にエラーとしない
@@ -522,52 +560,44 @@ func (g2ch *Get2ch) getSettingFile() ([]byte, error) {
 		}
 	}
 	// 返す際にUTF-8に変換
-	return unlib.ShiftJISToUtf8(data), nil
+	return g2ch.client.codec.Decode(data)
 }
 
-func (g2ch *Get2ch) sliceBoardName() (bname string) {
-	stf, err := g2ch.getSettingFile()
+func (g2ch *Get2ch) sliceBoardName(ctx context.Context) (bname string) {
+	bs, err := g2ch.GetBoardSettingsContext(ctx)
 	if err != nil {
 		return
 	}
-	start_text := []byte("BBS_TITLE=")
-	if start := bytes.Index(stf, start_text); start >= 0 {
-		start += len(start_text)
-		if end := bytes.IndexByte(stf[start:], '\n'); end >= 0 {
-			stf = stf[start : start+end]
-			var name string
-			if i := bytes.Index(stf, []byte("＠")); i >= 0 {
-				name = string(stf[:i])
-			} else {
-				name = string(stf)
-			}
-			bname = strings.Trim(name, " \t")
-		}
-	}
-	return
+	return bs.BoardName()
 }
 
 // header送信
-func (g2ch *Get2ch) request(flag bool) (data []byte) {
+func (g2ch *Get2ch) request(ctx context.Context, flag bool) (data []byte) {
 	var req *http.Request
 	var err error
 	server := g2ch.server
 	board := g2ch.board
 	thread := g2ch.thread
 	req_time := g2ch.req_time
+	limit := g2ch.limits.Subject
 
 	if server == "" {
 		// サーバが分からない
 		g2ch.code = 302
 		return
 	} else if g2ch.isThread() {
+		limit = g2ch.limits.Dat
 		// dat取得用header生成
-		req, err = http.NewRequest("GET", "http://"+g2ch.salami+server+"/"+board+"/dat/"+thread+".dat", nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", "http://"+g2ch.salami+server+"/"+board+"/dat/"+thread+".dat", nil)
 		if err != nil {
 			return
 		}
-		req.Header.Set("User-Agent", g_user_agent)
+		req.Header.Set("User-Agent", g2ch.user_agent)
 
+		if rc, ok := g2ch.cache.(CacheRepairer); ok && flag {
+			// 壊れた最終行があるとRangeがずれる
+			rc.Repair(server, board, thread)
+		}
 		st, err := g2ch.cache.Stat(server, board, thread)
 		if flag && err == nil {
 			size := st.Size()
@@ -575,6 +605,13 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 				// 1バイト引いても差分取得ができる場合
 				// 1バイト引いて取得する
 				req.Header.Set("Range", "bytes="+strconv.Itoa(int(size-1))+"-")
+				if limit >= 0 {
+					// 上限はキャッシュと合わせたサイズで判定する
+					limit -= size - 1
+					if limit < 1 {
+						limit = 1
+					}
+				}
 			}
 			req.Header.Set("If-Modified-Since", unlib.CreateModString(st.Mmod()))
 		} else {
@@ -583,11 +620,11 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 		}
 	} else if g2ch.isBoard() {
 		// スレッド一覧取得用header生成
-		req, err = http.NewRequest("GET", "http://"+g2ch.salami+server+"/"+board+"/"+FILE_SUBJECT_TXT_REQ, nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", "http://"+g2ch.salami+server+"/"+board+"/"+FILE_SUBJECT_TXT_REQ, nil)
 		if err != nil {
 			return
 		}
-		req.Header.Set("User-Agent", g_user_agent)
+		req.Header.Set("User-Agent", g2ch.user_agent)
 
 		if st, err := g2ch.cache.Stat(server, board, ""); err == nil {
 			req.Header.Set("If-Modified-Since", unlib.CreateModString(st.Mmod()))
@@ -597,16 +634,21 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 		g2ch.code = 0
 		return
 	}
-	req.Header.Set("Connection", "close")
+	g2ch.client.setConnection(req)
 
 	// リクエスト送信
-	var resp *http.Response
-	client := newHttpClient()
-	resp, err = client.Do(req)
-	if err != nil {
-		// errがnil以外の場合、resp.Bodyは閉じられている
+	ex := g2ch.client.do(ctx, g2ch.salami+server, req, g2ch.timeouts, limit)
+	g2ch.transfer += ex.n
+	g2ch.attempts += ex.attempts
+	resp, data, err := ex.resp, ex.data, ex.err
+	if err != nil && errors.Is(err, ErrTruncated) {
+		// 切り詰めたデータはキャッシュに書き込まない
+		g2ch.truncated = true
+		g2ch.reqerr = err
+	} else if err != nil {
 		if resp == nil {
 			g2ch.code = 0
+			g2ch.reqerr = err
 		} else {
 			if rerr := unlib.GetRedirectError(err); rerr != nil {
 				// RedirectErrorだった場合は処理続行
@@ -621,14 +663,6 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 			}
 		}
 		// 終了
-		return
-	}
-	defer resp.Body.Close()
-
-	// 読み込み
-	data, err = responseRead(resp)
-	if err != nil {
-		g2ch.code = 0
 		return nil
 	}
 
@@ -647,6 +681,7 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 		data = lfCheck(data)
 		if data == nil {
 			g2ch.code = 416
+			g2ch.abone = true
 		}
 	}
 	if mod != 0 {
@@ -657,26 +692,28 @@ func (g2ch *Get2ch) request(flag bool) (data []byte) {
 	return
 }
 
-func (g2ch *Get2ch) bourbonRequest() (data []byte) {
+func (g2ch *Get2ch) bourbonRequest(ctx context.Context) (data []byte) {
 	var req *http.Request
 	var err error
 	server := g2ch.server
 	board := g2ch.board
 	thread := g2ch.thread
 	strerr := append(make([]byte, 0, len(tanpanman)), tanpanman...)
+	limit := g2ch.limits.Subject
 
 	if server == "" {
 		// サーバが分からない
 		return
 	} else if g2ch.isThread() {
+		limit = g2ch.limits.Dat
 		// dat取得用header生成
-		req, err = http.NewRequest("GET", "http://"+g2ch.salami+BOURBON_HOST+"/test/r.so/"+server+"/"+board+"/"+thread+"/", nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", "http://"+g2ch.salami+BOURBON_HOST+"/test/r.so/"+server+"/"+board+"/"+thread+"/", nil)
 		if err != nil {
 			return
 		}
 	} else if g2ch.isBoard() {
 		// スレッド一覧取得用header生成
-		req, err = http.NewRequest("GET", "http://"+g2ch.salami+BOURBON_HOST+"/test/p.so/"+server+"/"+board+"/", nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", "http://"+g2ch.salami+BOURBON_HOST+"/test/p.so/"+server+"/"+board+"/", nil)
 		if err != nil {
 			return
 		}
@@ -687,25 +724,22 @@ func (g2ch *Get2ch) bourbonRequest() (data []byte) {
 	} else {
 		return strerr
 	}
-	req.Header.Set("User-Agent", g_user_agent)
+	req.Header.Set("User-Agent", g2ch.user_agent)
 	req.Header.Set("Accept-Encoding", "gzip")
-	req.Header.Set("Connection", "close")
+	g2ch.client.setConnection(req)
 
 	// リクエスト送信
-	var resp *http.Response
-	client := newHttpClient()
-	resp, err = client.Do(req)
-	if err != nil {
-		// errがnil以外の場合、resp.Bodyは閉じられている
-		g2ch.code = 0
-		return strerr
-	}
-	defer resp.Body.Close()
-
-	// 読み込み
-	data, err = responseRead(resp)
-	if err != nil {
+	ex := g2ch.client.do(ctx, g2ch.salami+BOURBON_HOST, req, g2ch.timeouts, limit)
+	g2ch.transfer += ex.n
+	g2ch.attempts += ex.attempts
+	resp, data, err := ex.resp, ex.data, ex.err
+	if err != nil && errors.Is(err, ErrTruncated) {
+		// 切り詰めたデータはキャッシュに書き込まない
+		g2ch.truncated = true
+		g2ch.reqerr = err
+	} else if err != nil {
 		g2ch.code = 0
+		g2ch.reqerr = err
 		return strerr
 	}
 
@@ -715,24 +749,39 @@ func (g2ch *Get2ch) bourbonRequest() (data []byte) {
 	return data
 }
 
-func (g2ch *Get2ch) normalData(reget bool) []byte {
+func (g2ch *Get2ch) normalData(ctx context.Context, reget bool) []byte {
 	var err error
 	// データ取得
-	data := g2ch.request(reget)
+	data := g2ch.request(ctx, reget)
+	if ctx.Err() != nil {
+		// 中断された場合はキャッシュも触らない
+		return nil
+	}
+	if g2ch.truncated {
+		return g2ch.truncatedData(data)
+	}
 	if g2ch.isThread() {
 		switch g2ch.code {
 		case 200:
 			g2ch.createCache(data, DAT_CREATE)
+			g2ch.source = SOURCE_NETWORK
 		case 206:
 			g2ch.createCache(data, DAT_APPEND)
+			diff := int64(len(data))
 			data, err = g2ch.readThread()
 			if err != nil {
 				data = g2ch.dataErrorDat()
+			} else {
+				g2ch.source = SOURCE_NETWORK
+				if diff > 0 && diff <= int64(len(data)) {
+					g2ch.appended = true
+					g2ch.append_at = int64(len(data)) - diff
+				}
 			}
 		case 416:
 			if reget {
 				// もう一回取得
-				data = g2ch.normalData(false)
+				data = g2ch.normalData(ctx, false)
 			} else {
 				data, err = g2ch.readThread()
 				if err != nil {
@@ -743,8 +792,9 @@ func (g2ch *Get2ch) normalData(reget bool) []byte {
 			if st, staterr := g2ch.cache.Stat(g2ch.server, g2ch.board, g2ch.thread); staterr == nil {
 				g2ch.size = st.Size()
 				g2ch.mod = st.Mmod()
-				if g2ch.size < DAT_MAX_SIZE {
+				if withinLimit(g2ch.size, g2ch.limits.Dat) {
 					data, _ = g2ch.cache.GetData(g2ch.server, g2ch.board, g2ch.thread)
+					g2ch.source = SOURCE_CACHE
 					// バーボンキャッシュ更新
 					g2ch.updateBourbonCache(g2ch.bourbon)
 				} else {
@@ -764,11 +814,12 @@ func (g2ch *Get2ch) normalData(reget bool) []byte {
 		switch g2ch.code {
 		case 200:
 			g2ch.createCache(data, DAT_CREATE)
+			g2ch.source = SOURCE_NETWORK
 		case 301, 302, 404:
 			// 鯖情報取得
-			saveBBSmenu(g2ch.cache)
+			g2ch.client.saveBBSmenu(ctx, g2ch.cache)
 			data = []byte{}
-			g2ch.err = errors.New("２ちゃんねるにアクセスできなかったので、サーバー移転チェックを行いました。")
+			g2ch.err = g2ch.statusError(ErrBoardMoved)
 		default:
 			// キャッシュ利用
 			data, err = g2ch.readBoard()
@@ -780,14 +831,18 @@ func (g2ch *Get2ch) normalData(reget bool) []byte {
 	return data
 }
 
-func (g2ch *Get2ch) bourbonData() (data []byte) {
+func (g2ch *Get2ch) bourbonData(ctx context.Context) (data []byte) {
 	g2ch.bourbon = true
 
 	if strings.Contains(g2ch.server, ".bbspink.com") {
 		// BBSPINKだった場合
 		data = append(make([]byte, 0, len(tanpanman)), tanpanman...)
 	} else {
-		data = g2ch.bourbonRequest()
+		data = g2ch.bourbonRequest(ctx)
+	}
+	if ctx.Err() != nil {
+		// 中断された場合はキャッシュも触らない
+		return nil
 	}
 	tp := append(make([]byte, 0, len(tanpanman)), tanpanman...)
 	ne := append(make([]byte, 0, len(nagoyaee)), nagoyaee...)
@@ -801,8 +856,9 @@ func (g2ch *Get2ch) bourbonData() (data []byte) {
 		if st, staterr := g2ch.cache.Stat(g2ch.server, g2ch.board, g2ch.thread); staterr == nil {
 			g2ch.mod = st.Mmod()
 			g2ch.size = st.Size()
-			if g2ch.size < DAT_MAX_SIZE {
+			if withinLimit(g2ch.size, g2ch.limits.Dat) {
 				data, _ = g2ch.cache.GetData(g2ch.server, g2ch.board, g2ch.thread)
+				g2ch.source = SOURCE_CACHE
 			} else {
 				data = g2ch.dataError()
 			}
@@ -811,7 +867,11 @@ func (g2ch *Get2ch) bourbonData() (data []byte) {
 		}
 	} else {
 		// 取得に成功した場合
-		if g2ch.cache.Exists(g2ch.server, g2ch.board, g2ch.thread) {
+		g2ch.source = SOURCE_BOURBON
+		if g2ch.truncated {
+			// 切り詰めたデータはキャッシュに書き込まない
+			g2ch.err = g2ch.statusError(g2ch.reqerr)
+		} else if g2ch.cache.Exists(g2ch.server, g2ch.board, g2ch.thread) {
 			if g2ch.isThread() {
 				g2ch.createCache(data, DAT_BOURBON_THREAD)
 			} else {
@@ -824,9 +884,30 @@ func (g2ch *Get2ch) bourbonData() (data []byte) {
 	return
 }
 
+// サイズ上限で切り詰めた場合
+// 差分取得だった場合はキャッシュを返す
+func (g2ch *Get2ch) truncatedData(data []byte) []byte {
+	if g2ch.code == 200 {
+		g2ch.source = SOURCE_NETWORK
+	} else {
+		var err error
+		if g2ch.isThread() {
+			data, err = g2ch.readThread()
+		} else {
+			data, err = g2ch.readBoard()
+		}
+		if err != nil {
+			data = nil
+		}
+	}
+	g2ch.err = g2ch.statusError(g2ch.reqerr)
+	return data
+}
+
 func (g2ch *Get2ch) dataError() []byte {
 	data := bytes.Buffer{}
-	g2ch.err = errors.New("壊れているため表示できません。")
+	g2ch.source = SOURCE_NONE
+	g2ch.err = g2ch.statusError(ErrDatBroken)
 	if g2ch.isThread() {
 		data.WriteString("unkar.org<><>")
 		data.WriteString(unlib.CreateDateString(g2ch.req_time))
@@ -835,13 +916,25 @@ func (g2ch *Get2ch) dataError() []byte {
 		data.WriteString(strconv.Itoa(int(g2ch.req_time)))
 		data.WriteString(".dat<>板が壊れているため表示できません (1)\n")
 	}
-	return unlib.Utf8ToShiftJIS(data.Bytes())
+	// 固定の文言なので変換に失敗しない
+	d, _ := g2ch.client.codec.Encode(data.Bytes())
+	return d
 }
 
 func (g2ch *Get2ch) dataErrorDat() []byte {
 	data := bytes.Buffer{}
-	g2ch.err = errors.New("アクセス不可(dat落ち)")
-	if g2ch.isThread() {
+	g2ch.source = SOURCE_NONE
+	if g2ch.reqerr != nil {
+		// 通信できなかった場合はdat落ちと判断しない
+		g2ch.err = g2ch.statusError(g2ch.reqerr)
+	} else {
+		g2ch.err = g2ch.statusError(ErrDatDropped)
+	}
+	if g2ch.isThread() && g2ch.reqerr != nil {
+		data.WriteString("unkar.org<><>")
+		data.WriteString(unlib.CreateDateString(g2ch.req_time))
+		data.WriteString("<>２ちゃんねるにアクセスできませんでした。<>アクセス不可\n")
+	} else if g2ch.isThread() {
 		data.WriteString("unkar.org<><>")
 		data.WriteString(unlib.CreateDateString(g2ch.req_time))
 		data.WriteString("<>スレッドを発見できませんでした。dat落ちのようです。<>アクセス不可(dat落ち)\n")
@@ -849,7 +942,9 @@ func (g2ch *Get2ch) dataErrorDat() []byte {
 		data.WriteString(strconv.Itoa(int(g2ch.req_time)))
 		data.WriteString(".dat<>２ちゃんねるにアクセスできませんでした。 (1)\n")
 	}
-	return unlib.Utf8ToShiftJIS(data.Bytes())
+	// 固定の文言なので変換に失敗しない
+	d, _ := g2ch.client.codec.Encode(data.Bytes())
+	return d
 }
 
 // 必ずSJIS-winの状態で渡す
@@ -869,7 +964,7 @@ func (g2ch *Get2ch) createCache(data []byte, switch_data int) error {
 	renew := true
 
 	if data == nil {
-		return errors.New("data nil")
+		return errNilData
 	}
 
 	switch switch_data {
@@ -921,12 +1016,12 @@ func (g2ch *Get2ch) createCache(data []byte, switch_data int) error {
 }
 
 func (g2ch *Get2ch) getBourbonCache() bool {
-	return bbnCacheObj.GetBourbon(g2ch.salami)
+	return g2ch.client.bbnCache.GetBourbon(g2ch.salami)
 }
 
 func (g2ch *Get2ch) updateBourbonCache(bin bool) {
 	if bin {
-		bbnCacheObj.SetBourbon(g2ch.salami)
+		g2ch.client.bbnCache.SetBourbon(g2ch.salami)
 	}
 }
 
@@ -936,6 +1031,9 @@ func (g2ch *Get2ch) readThread() (data []byte, err error) {
 		g2ch.size = st.Size()
 		g2ch.mod = st.Mmod()
 		data, err = g2ch.cache.GetData(g2ch.server, g2ch.board, g2ch.thread)
+		if err == nil {
+			g2ch.source = SOURCE_CACHE
+		}
 	}
 	return
 }
@@ -946,6 +1044,9 @@ func (g2ch *Get2ch) readBoard() (data []byte, err error) {
 		g2ch.size = st.Size()
 		g2ch.mod = st.Mmod()
 		data, err = g2ch.cache.GetData(g2ch.server, g2ch.board, "")
+		if err == nil {
+			g2ch.source = SOURCE_CACHE
+		}
 	}
 	return
 }
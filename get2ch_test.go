@@ -0,0 +1,208 @@
+package get2ch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ステータスコード毎の差分取得とキャッシュの扱い
+func TestFetchStatus(t *testing.T) {
+	rng := "bytes=" + strconv.Itoa(len(testDat1)-1) + "-"
+	tests := []struct {
+		name   string
+		cached string // 空の場合はキャッシュ無し
+		fn     func(req *http.Request) (*http.Response, error)
+		code   int
+		source Source
+		data   string
+		abone  bool
+		err    error
+		reqs   int
+	}{
+		{"200", "", func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, 200, testDat1), nil
+		}, 200, SOURCE_NETWORK, testDat1, false, nil, 1},
+		{"304", testDat1, func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("If-Modified-Since") == "" || req.Header.Get("Range") != rng {
+				return fakeResponse(req, 200, testDat1), nil
+			}
+			return fakeResponse(req, 304, ""), nil
+		}, 304, SOURCE_CACHE, testDat1, false, nil, 1},
+		{"206", testDat1, func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Range") != rng {
+				return fakeResponse(req, 200, testDat1), nil
+			}
+			return fakeResponse(req, 206, "\n"+testDat2), nil
+		}, 206, SOURCE_NETWORK, testDat1 + testDat2, false, nil, 1},
+		{"206 abone", testDat1, func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Range") != "" {
+				// 1バイト前が改行ではない
+				return fakeResponse(req, 206, "x"+testDat2), nil
+			}
+			return fakeResponse(req, 200, testDat2), nil
+		}, 200, SOURCE_NETWORK, testDat2, true, nil, 2},
+		{"416", testDat1, func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Range") != "" {
+				return fakeResponse(req, 416, ""), nil
+			}
+			return fakeResponse(req, 200, testDat2), nil
+		}, 200, SOURCE_NETWORK, testDat2, false, nil, 2},
+		{"404 cached", testDat1, func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, 404, ""), nil
+		}, 404, SOURCE_CACHE, testDat1, false, nil, 1},
+		{"404", "", func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, 404, ""), nil
+		}, 404, SOURCE_NONE, "", false, ErrDatDropped, 1},
+	}
+	for _, tt := range tests {
+		cache := NewMemoryCache(0, 0)
+		if tt.cached != "" {
+			cache.SetData(testServer, testBoard, testThread, []byte(tt.cached))
+			cache.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000)
+		}
+		c, ft := newFakeClient(t, cache, Options{}, tt.fn)
+		g2ch, _ := c.NewGet2ch(testBoard, testThread)
+		res, err := g2ch.Fetch()
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: Fetch() err = %v, want %v", tt.name, err, tt.err)
+		}
+		if res.Code != tt.code || res.Source != tt.source || res.Abone != tt.abone {
+			t.Errorf("%s: Fetch() = code %d source %v abone %v", tt.name, res.Code, res.Source, res.Abone)
+		}
+		if tt.data != "" && string(res.Data) != tt.data {
+			t.Errorf("%s: Data = %q, want %q", tt.name, res.Data, tt.data)
+		}
+		if tt.source == SOURCE_NETWORK {
+			if d, _ := cache.GetData(testServer, testBoard, testThread); string(d) != tt.data {
+				t.Errorf("%s: cache = %q, want %q", tt.name, d, tt.data)
+			}
+		}
+		if n := len(ft.requests()); n != tt.reqs {
+			t.Errorf("%s: requests = %d, want %d", tt.name, n, tt.reqs)
+		}
+	}
+}
+
+// 403へのリダイレクトでバーボンと判定し、次からはキャッシュサーバから取得する
+func TestFetchBourbon(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string // キャッシュサーバの応答
+		source Source
+		data   string
+	}{
+		{"bourbon", testDat1 + testDat2, SOURCE_BOURBON, testDat1 + testDat2},
+		{"fallback", string(tanpanman) + "\n", SOURCE_CACHE, testDat1},
+	}
+	for _, tt := range tests {
+		cache := NewMemoryCache(0, 0)
+		cache.SetData(testServer, testBoard, testThread, []byte(testDat1))
+		c, ft := newFakeClient(t, cache, Options{}, func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Host {
+			case BOURBON_HOST:
+				return fakeResponse(req, 200, tt.body), nil
+			case testServer:
+				if req.URL.Path == "/403/" {
+					return fakeResponse(req, 403, ""), nil
+				}
+				resp := fakeResponse(req, 302, "")
+				resp.Header.Set("Location", "http://"+testServer+"/403/")
+				return resp, nil
+			}
+			return fakeResponse(req, 404, ""), nil
+		})
+		g2ch, _ := c.NewGet2ch(testBoard, testThread)
+		res, err := g2ch.Fetch()
+		if err != nil || res.Code != 302 || res.Source != SOURCE_CACHE || string(res.Data) != testDat1 {
+			t.Fatalf("%s: Fetch() = %d %v %q, %v", tt.name, res.Code, res.Source, res.Data, err)
+		}
+		// バーボン状態は非同期に記録される
+		deadline := time.Now().Add(time.Second)
+		for !c.bbnCache.GetBourbon("") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		res, err = g2ch.Fetch()
+		if err != nil || res.Source != tt.source || string(res.Data) != tt.data {
+			t.Errorf("%s: Fetch() bourbon = %d %v %q, %v", tt.name, res.Code, res.Source, res.Data, err)
+		}
+		reqs := ft.requests()
+		if last := reqs[len(reqs)-1]; last.URL.Host != BOURBON_HOST || last.URL.Path != "/test/r.so/"+testServer+"/"+testBoard+"/"+testThread+"/" {
+			t.Errorf("%s: request = %v", tt.name, last.URL)
+		}
+	}
+}
+
+// 取得のどの段階でも中断でき、ctx.Err()を返してキャッシュは触らない
+func TestFetchCancel(t *testing.T) {
+	block := func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	tests := []struct {
+		name    string
+		opt     Options
+		fn      func(req *http.Request) (*http.Response, error)
+		prepare func(c *Client) func() // 中断前の準備 戻り値は後始末
+		want    error
+	}{
+		{"request", Options{}, block, nil, context.Canceled},
+		{"retry wait", Options{Retry: &Backoff{MaxAttempts: 2, Base: time.Hour}}, func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, 503, ""), nil
+		}, nil, context.Canceled},
+		{"rate limit", Options{RateLimit: RateLimit{RequestsPerSecond: 0.001}}, func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, 304, ""), nil
+		}, func(c *Client) func() {
+			// 1回目で使い切る
+			g2ch, _ := c.NewGet2ch(testBoard, testThread)
+			g2ch.Fetch()
+			return func() {}
+		}, context.Canceled},
+		{"fetch lock", Options{}, block, func(c *Client) func() {
+			unlock, err := c.lockFetch(context.Background(), c.getCache(), testServer, testBoard, testThread)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return unlock
+		}, context.Canceled},
+	}
+	for _, tt := range tests {
+		cache := NewMemoryCache(0, 0)
+		cache.SetData(testServer, testBoard, testThread, []byte(testDat1))
+		c, _ := newFakeClient(t, cache, tt.opt, tt.fn)
+		if tt.prepare != nil {
+			defer tt.prepare(c)()
+		}
+		g2ch, _ := c.NewGet2ch(testBoard, testThread)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		res, err := g2ch.FetchContext(ctx)
+		if !errors.Is(err, tt.want) || res.Data != nil {
+			t.Errorf("%s: FetchContext() = %q, %v, want %v", tt.name, res.Data, err, tt.want)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: FetchContext() took %v", tt.name, d)
+		}
+		if d, _ := cache.GetData(testServer, testBoard, testThread); string(d) != testDat1 {
+			t.Errorf("%s: cache = %q", tt.name, d)
+		}
+		cancel()
+	}
+
+	// 中断済みの場合は通信しない
+	c, ft := newFakeClient(t, nil, Options{}, block)
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if _, err := g2ch.FetchContext(ctx); !errors.Is(err, context.DeadlineExceeded) || g2ch.GetError() != err {
+		t.Errorf("FetchContext() err = %v, GetError() = %v", err, g2ch.GetError())
+	}
+	if n := len(ft.requests()); n != 0 {
+		t.Errorf("requests = %d, want 0", n)
+	}
+}
@@ -0,0 +1,31 @@
+// 文字コード変換
+package codec
+
+import (
+	"errors"
+	"io"
+)
+
+// 変換できない文字の扱い
+type Mode int
+
+// 変換先がUTF-8の場合、変換できないのは不正なバイトのみ
+// 不正なバイトを失わずに読む場合はMODE_RAWを使う
+// RAW_BASE+バイト列の文字はどの設定でも元のバイト列に書き戻す
+const (
+	MODE_REPLACE Mode = iota // 置換文字にする
+	MODE_NCR                 // 数値文字参照(&#12345;)にする 変換先がUTF-8の場合は置換と同じ
+	MODE_ERROR               // エラーにする
+	MODE_RAW                 // 元のバイト列を私用面の文字(RAW_BASE+バイト列)にして保持する 変換先がCP932の場合は数値文字参照と同じ
+)
+
+var ErrInvalid = errors.New("変換できない文字が含まれています")
+
+// 変換方式
+// DecodeはUTF-8へ、EncodeはUTF-8から変換する
+type Codec interface {
+	Decode(src []byte) ([]byte, error)
+	Encode(src []byte) ([]byte, error)
+	NewReader(r io.Reader) io.Reader      // 読みながらUTF-8へ変換する
+	NewWriter(w io.Writer) io.WriteCloser // UTF-8を書き込むと変換する Closeで残りを書き出す
+}
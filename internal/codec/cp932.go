@@ -0,0 +1,255 @@
+package codec
+
+// CP932(SJIS-win)
+// NEC特殊文字、NEC選定IBM拡張文字、IBM拡張文字、外字(私用領域)を扱う
+// 同じ文字に複数の符号がある場合、Windowsと同じく
+// JIS X 0208、NEC特殊文字、IBM拡張文字、NEC選定IBM拡張文字の順に優先して書き戻す
+// 元の符号のまま書き戻す必要がある場合はMODE_RAWで読む
+
+import (
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+	"io"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	REPLACE_CHAR = '?'      // CP932へ変換できない文字の置換文字
+	RAW_BASE     = 0x100000 // MODE_RAWで元のバイト列を表す文字の先頭 RAW_BASE+バイト列(1〜2バイト)
+	EUDC_BASE    = 0xE000   // 外字(F040〜F9FC)の変換先
+)
+
+var (
+	table_once   sync.Once
+	decode_table []uint16 // (第1バイト-0x81)*256+第2バイト → 文字 0は未定義
+	encode_table []uint16 // 文字 → 2バイトの符号 0は未定義
+)
+
+type cp932 struct {
+	mode Mode
+}
+
+func NewCP932(mode Mode) Codec {
+	table_once.Do(buildTables)
+	return &cp932{mode: mode}
+}
+
+func (c *cp932) Decode(src []byte) ([]byte, error) {
+	dst, _, err := transform.Bytes(c.decoder(), src)
+	return dst, err
+}
+
+func (c *cp932) Encode(src []byte) ([]byte, error) {
+	dst, _, err := transform.Bytes(c.encoder(), src)
+	return dst, err
+}
+
+func (c *cp932) NewReader(r io.Reader) io.Reader {
+	return transform.NewReader(r, c.decoder())
+}
+
+func (c *cp932) NewWriter(w io.Writer) io.WriteCloser {
+	return transform.NewWriter(w, c.encoder())
+}
+
+func (c *cp932) decoder() transform.Transformer {
+	return &decoder{mode: c.mode}
+}
+
+func (c *cp932) encoder() transform.Transformer {
+	return &encoder{mode: c.mode}
+}
+
+func isLead(c byte) bool {
+	return (0x81 <= c && c <= 0x9F) || (0xE0 <= c && c <= 0xFC)
+}
+
+func isTrail(c byte) bool {
+	return (0x40 <= c && c <= 0x7E) || (0x80 <= c && c <= 0xFC)
+}
+
+func tableIndex(lead, trail byte) int {
+	return (int(lead)-0x81)*256 + int(trail)
+}
+
+// 重複した符号の優先度 小さいほど優先する
+func codePriority(code uint16) int {
+	switch lead := code >> 8; {
+	case lead == 0x87:
+		// NEC特殊文字
+		return 1
+	case lead >= 0xFA:
+		// IBM拡張文字
+		return 2
+	case lead == 0xED || lead == 0xEE:
+		// NEC選定IBM拡張文字
+		return 3
+	}
+	return 0
+}
+
+// 2バイト文字の対応表を作る
+// 文字の対応はx/textから取り、外字と重複した符号の扱いを足す
+func buildTables() {
+	decode_table = make([]uint16, tableIndex(0xFC, 0xFF)+1)
+	encode_table = make([]uint16, 0x10000)
+	d := japanese.ShiftJIS.NewDecoder()
+	var dst [utf8.UTFMax * 2]byte
+	for lead := 0x81; lead <= 0xFC; lead++ {
+		if !isLead(byte(lead)) {
+			continue
+		}
+		for trail := 0x40; trail <= 0xFC; trail++ {
+			if !isTrail(byte(trail)) {
+				continue
+			}
+			var r rune
+			if 0xF0 <= lead && lead <= 0xF9 {
+				// 外字は私用領域へ
+				t := trail - 0x40
+				if trail > 0x7F {
+					t--
+				}
+				r = EUDC_BASE + rune((lead-0xF0)*188+t)
+			} else {
+				d.Reset()
+				n, _, err := d.Transform(dst[:], []byte{byte(lead), byte(trail)}, true)
+				if err != nil {
+					continue
+				}
+				var size int
+				r, size = utf8.DecodeRune(dst[:n])
+				if r == utf8.RuneError || size != n {
+					continue
+				}
+			}
+			code := uint16(lead<<8 | trail)
+			decode_table[tableIndex(byte(lead), byte(trail))] = uint16(r)
+			if old := encode_table[r]; old == 0 || codePriority(code) < codePriority(old) {
+				encode_table[r] = code
+			}
+		}
+	}
+}
+
+// CP932からUTF-8へ
+type decoder struct {
+	mode Mode
+}
+
+func (d *decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		c := src[nSrc]
+		if c < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = c
+			nDst++
+			nSrc++
+			continue
+		}
+		r := rune(-1) // 負の場合は不正なバイト
+		size := 1
+		raw := rune(c)
+		switch {
+		case c == 0x80:
+			r = 0x80
+		case 0xA1 <= c && c <= 0xDF:
+			// 半角カナ
+			r = 0xFF61 + rune(c-0xA1)
+		case isLead(c):
+			if nSrc+1 >= len(src) {
+				if !atEOF {
+					return nDst, nSrc, transform.ErrShortSrc
+				}
+				break
+			}
+			t := src[nSrc+1]
+			code := rune(c)<<8 | rune(t)
+			if isTrail(t) && decode_table[tableIndex(c, t)] != 0 {
+				size, raw = 2, code
+				r = rune(decode_table[tableIndex(c, t)])
+				if d.mode == MODE_RAW && rune(encode_table[r]) != code {
+					// 書き戻すと別の符号になる
+					r = RAW_BASE + raw
+				}
+			} else if t >= utf8.RuneSelf {
+				// ASCIIは次の文字として読む
+				size, raw = 2, code
+			}
+		}
+		if r < 0 {
+			switch d.mode {
+			case MODE_ERROR:
+				return nDst, nSrc, ErrInvalid
+			case MODE_RAW:
+				r = RAW_BASE + raw
+			default:
+				r = utf8.RuneError
+			}
+		}
+		if len(dst)-nDst < utf8.RuneLen(r) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+func (d *decoder) Reset() {}
+
+// UTF-8からCP932へ
+// 変換できない文字は設定に従って処理する
+type encoder struct {
+	mode Mode
+}
+
+func (e *encoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		c := src[nSrc]
+		if c < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = c
+			nDst++
+			nSrc++
+			continue
+		}
+		if !atEOF && !utf8.FullRune(src[nSrc:]) {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		r, size := utf8.DecodeRune(src[nSrc:])
+		var rep []byte
+		switch {
+		case r == 0x80:
+			rep = []byte{0x80}
+		case 0xFF61 <= r && r <= 0xFF9F:
+			rep = []byte{byte(r - 0xFF61 + 0xA1)}
+		case RAW_BASE <= r && r < RAW_BASE+0x100:
+			rep = []byte{byte(r - RAW_BASE)}
+		case RAW_BASE+0x100 <= r && r < RAW_BASE+0x10000:
+			rep = []byte{byte((r - RAW_BASE) >> 8), byte(r - RAW_BASE)}
+		case r < 0x10000 && encode_table[r] != 0:
+			rep = []byte{byte(encode_table[r] >> 8), byte(encode_table[r])}
+		case e.mode == MODE_ERROR:
+			return nDst, nSrc, ErrInvalid
+		case (e.mode == MODE_NCR || e.mode == MODE_RAW) && !(r == utf8.RuneError && size <= 1):
+			rep = []byte("&#" + strconv.Itoa(int(r)) + ";")
+		default:
+			rep = []byte{REPLACE_CHAR}
+		}
+		if len(dst)-nDst < len(rep) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], rep)
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+func (e *encoder) Reset() {}
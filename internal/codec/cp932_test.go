@@ -0,0 +1,193 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCP932Decode(t *testing.T) {
+	c := NewCP932(MODE_REPLACE)
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"abc", "abc"},
+		{"\x82\xa0\x82\xa2", "あい"},
+		{"\xb1\xb2", "ｱｲ"},
+		{"\x87\x40", "①"}, // NEC特殊文字
+		{"\xed\x40", "纊"}, // NEC選定IBM拡張文字
+		{"\xfa\x40", "ⅰ"}, // IBM拡張文字
+		{"\xf0\x40", ""}, // 外字
+		{"\x81\x39", "�9"},
+		{"\xa0", "�"},
+		{"\x82", "�"},
+	}
+	for _, tt := range tests {
+		got, err := c.Decode([]byte(tt.src))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Decode(%q) = %q, %v, want %q", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+// Windowsと同じ符号を優先して書き戻す
+func TestCP932Encode(t *testing.T) {
+	c := NewCP932(MODE_REPLACE)
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"あい", "\x82\xa0\x82\xa2"},
+		{"ｱｲ", "\xb1\xb2"},
+		{"ⅰ", "\xfa\x40"}, // IBM拡張文字
+		{"纊", "\xfa\x5c"}, // NEC選定IBM拡張文字よりIBM拡張文字
+		{"Ⅰ", "\x87\x54"}, // IBM拡張文字よりNEC特殊文字
+		{"≒", "\x81\xe0"}, // NEC特殊文字よりJIS X 0208
+		{"∵", "\x81\xe6"},
+		{"￢", "\x81\xca"},
+		{"", "\xf0\x40"},
+		{"😀", "?"},
+	}
+	for _, tt := range tests {
+		got, err := c.Encode([]byte(tt.src))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Encode(%q) = % X, %v, want % X", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+// 全ての2バイト文字について、MODE_RAWで読んで書き戻すと元のバイト列になる
+func TestCP932RawRoundTrip(t *testing.T) {
+	c := NewCP932(MODE_RAW)
+	var src []byte
+	for lead := 0x81; lead <= 0xFC; lead++ {
+		for trail := 0x40; trail <= 0xFC; trail++ {
+			if trail == 0x7F {
+				continue
+			}
+			src = append(src, byte(lead), byte(trail))
+		}
+	}
+	// 1バイト文字と不正なバイト
+	for b := 0; b < 0x100; b++ {
+		if !isLead(byte(b)) {
+			src = append(src, byte(b))
+		}
+	}
+	src = append(src, 0x81, 0x39, 0x81)
+	d, err := c.Decode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Encode(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		for i := range src {
+			if i >= len(got) || got[i] != src[i] {
+				t.Fatalf("round trip differs at %d: % X", i, src[i:i+2])
+			}
+		}
+		t.Fatalf("round trip length = %d, want %d", len(got), len(src))
+	}
+}
+
+// 重複した符号はMODE_RAWの場合だけ元の符号に戻る
+func TestCP932Duplicates(t *testing.T) {
+	tests := []struct {
+		src     string
+		char    string
+		replace string // MODE_REPLACEで書き戻した結果
+	}{
+		{"\xfa\x40", "ⅰ", "\xfa\x40"},
+		{"\xee\xef", "ⅰ", "\xfa\x40"},
+		{"\xfa\x5b", "∵", "\x81\xe6"},
+		{"\x87\x90", "≒", "\x81\xe0"},
+		{"\xee\xf9", "￢", "\x81\xca"},
+	}
+	raw := NewCP932(MODE_RAW)
+	rep := NewCP932(MODE_REPLACE)
+	for _, tt := range tests {
+		d, _ := rep.Decode([]byte(tt.src))
+		if string(d) != tt.char {
+			t.Errorf("Decode(% X) = %q, want %q", tt.src, d, tt.char)
+		}
+		if e, _ := rep.Encode(d); string(e) != tt.replace {
+			t.Errorf("MODE_REPLACE: % X -> % X, want % X", tt.src, e, tt.replace)
+		}
+		d, _ = raw.Decode([]byte(tt.src))
+		if e, _ := raw.Encode(d); string(e) != tt.src {
+			t.Errorf("MODE_RAW: % X -> % X", tt.src, e)
+		}
+	}
+}
+
+func TestCP932Modes(t *testing.T) {
+	invalid := []byte("a\xa0b")
+	unencodable := []byte("a😀b")
+	tests := []struct {
+		mode   Mode
+		decode string
+		encode string
+		err    bool
+	}{
+		{MODE_REPLACE, "a�b", "a?b", false},
+		{MODE_NCR, "a�b", "a&#128512;b", false},
+		{MODE_RAW, "a\U001000a0b", "a&#128512;b", false},
+		{MODE_ERROR, "", "", true},
+	}
+	for _, tt := range tests {
+		c := NewCP932(tt.mode)
+		d, err := c.Decode(invalid)
+		if tt.err {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("mode %d: Decode err = %v", tt.mode, err)
+			}
+		} else if err != nil || string(d) != tt.decode {
+			t.Errorf("mode %d: Decode = %q, %v, want %q", tt.mode, d, err, tt.decode)
+		}
+		e, err := c.Encode(unencodable)
+		if tt.err {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("mode %d: Encode err = %v", tt.mode, err)
+			}
+		} else if err != nil || string(e) != tt.encode {
+			t.Errorf("mode %d: Encode = %q, %v, want %q", tt.mode, e, err, tt.encode)
+		}
+	}
+}
+
+// 1バイトずつ渡しても文字の途中で切れない
+func TestCP932Stream(t *testing.T) {
+	c := NewCP932(MODE_RAW)
+	src := []byte("\x82\xa0\xfa\x5b\x81\x39\xb1abc\x87\x90")
+	d, err := ioutil.ReadAll(c.NewReader(iotest.OneByteReader(bytes.NewReader(src))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := c.Decode(src)
+	if !bytes.Equal(d, want) {
+		t.Fatalf("NewReader = %q, want %q", d, want)
+	}
+	var buf bytes.Buffer
+	w := c.NewWriter(&buf)
+	for _, b := range d {
+		if _, err := w.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), src) {
+		t.Fatalf("NewWriter = % X, want % X", buf.Bytes(), src)
+	}
+	if s, _ := c.Encode([]byte(strings.Repeat("あ", 4096))); len(s) != 8192 {
+		t.Errorf("Encode length = %d", len(s))
+	}
+}
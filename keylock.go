@@ -0,0 +1,82 @@
+package get2ch
+
+import (
+	"context"
+	"sync"
+)
+
+// キー毎の排他
+// 待っている間もcontextで中断できる
+type keyLock struct {
+	m   map[string]*keyLockEntry
+	mux sync.Mutex
+}
+
+type keyLockEntry struct {
+	ch  chan struct{}
+	ref int // 使用中と待機中の数 0になったら消す
+}
+
+func newKeyLock() *keyLock {
+	return &keyLock{
+		m: make(map[string]*keyLockEntry, 64),
+	}
+}
+
+func (kl *keyLock) lock(ctx context.Context, key string) (func(), error) {
+	kl.mux.Lock()
+	e, ok := kl.m[key]
+	if !ok {
+		e = &keyLockEntry{ch: make(chan struct{}, 1)}
+		kl.m[key] = e
+	}
+	e.ref++
+	kl.mux.Unlock()
+
+	select {
+	case e.ch <- struct{}{}:
+	case <-ctx.Done():
+		kl.release(key, e)
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-e.ch
+		kl.release(key, e)
+	}, nil
+}
+
+func (kl *keyLock) release(key string, e *keyLockEntry) {
+	kl.mux.Lock()
+	e.ref--
+	if e.ref == 0 {
+		delete(kl.m, key)
+	}
+	kl.mux.Unlock()
+}
+
+// 同じデータの取得と書き込みを直列化する
+// 同じプロセス内はClientで、他のプロセスとはCacheLockerで排他する
+// 保存先が異なれば別のデータとして扱う
+func (c *Client) lockFetch(ctx context.Context, cache Cache, s, b, t string) (func(), error) {
+	if !validKey(t) {
+		// Pathはキーの長さを前提にしている
+		return nil, ErrInvalidKey
+	}
+	unlock, err := c.fetching.lock(ctx, s+"\x00"+cache.Path(s, b, t))
+	if err != nil {
+		return nil, err
+	}
+	cl, ok := cache.(CacheLocker)
+	if !ok {
+		return unlock, nil
+	}
+	cunlock, err := cl.Lock(ctx, s, b, t)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		cunlock()
+		unlock()
+	}, nil
+}
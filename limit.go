@@ -0,0 +1,61 @@
+package get2ch
+
+import (
+	"strconv"
+)
+
+// 取得するデータのサイズ上限
+// 0の項目は標準の設定、負の値は上限なし
+type Limits struct {
+	Dat     int64 // スレッドのdat
+	Subject int64 // subject.txt
+	Setting int64 // SETTING.TXT
+	BBSmenu int64 // bbsmenu.html
+}
+
+func defaultLimits() Limits {
+	return Limits{
+		Dat:     DAT_MAX_SIZE,
+		Subject: DAT_MAX_SIZE,
+		Setting: DAT_MAX_SIZE,
+		BBSmenu: DAT_MAX_SIZE,
+	}
+}
+
+// 0以外の値で上書きする
+func (l Limits) merge(o Limits) Limits {
+	if o.Dat != 0 {
+		l.Dat = o.Dat
+	}
+	if o.Subject != 0 {
+		l.Subject = o.Subject
+	}
+	if o.Setting != 0 {
+		l.Setting = o.Setting
+	}
+	if o.BBSmenu != 0 {
+		l.BBSmenu = o.BBSmenu
+	}
+	return l
+}
+
+// 上限に収まっているか
+// 切り詰めの判定(上限を超えた場合)と合わせる
+func withinLimit(size, limit int64) bool {
+	return limit < 0 || size <= limit
+}
+
+// サイズ上限で切り詰めた場合のエラー
+// errors.Is(err, ErrTruncated)で判定できる
+type TruncatedError struct {
+	Limit int64 // 上限のバイト数
+	URL   string
+}
+
+func (e *TruncatedError) Error() string {
+	return ErrTruncated.Error() + " (" + strconv.FormatInt(e.Limit, 10) + " bytes) " + e.URL
+}
+
+func (e *TruncatedError) Is(target error) bool {
+	return target == ErrTruncated
+}
@@ -0,0 +1,58 @@
+package get2ch
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// 上限を超えた場合は切り詰めたことを知らせ、キャッシュには書き込まない
+func TestFetchTruncated(t *testing.T) {
+	const subject = "1234567890.dat<>test (1)\n1234567891.dat<>test (2)\n"
+	tests := []struct {
+		name      string
+		thread    string
+		cached    string
+		limits    Limits
+		body      string
+		truncated bool
+		source    Source
+		data      string
+	}{
+		{"dat", testThread, "", Limits{Dat: 10}, testDat1, true, SOURCE_NETWORK, testDat1[:10]},
+		{"append", testThread, testDat1, Limits{Dat: int64(len(testDat1)) + 5}, "\n" + testDat2, true, SOURCE_CACHE, testDat1},
+		{"subject", "", "", Limits{Subject: 10}, subject, true, SOURCE_NETWORK, subject[:10]},
+		{"no limit", testThread, "", Limits{Dat: -1}, testDat1, false, SOURCE_NETWORK, testDat1},
+	}
+	for _, tt := range tests {
+		cache := NewMemoryCache(0, 0)
+		if tt.cached != "" {
+			cache.SetData(testServer, testBoard, tt.thread, []byte(tt.cached))
+		}
+		c, _ := newFakeClient(t, cache, Options{Limits: tt.limits}, func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Range") != "" {
+				return fakeResponse(req, 206, tt.body), nil
+			}
+			return fakeResponse(req, 200, tt.body), nil
+		})
+		g2ch, _ := c.NewGet2ch(testBoard, tt.thread)
+		res, err := g2ch.Fetch()
+		if res.Truncated != tt.truncated || errors.Is(err, ErrTruncated) != tt.truncated {
+			t.Errorf("%s: Fetch() truncated = %v, err = %v", tt.name, res.Truncated, err)
+		}
+		var terr *TruncatedError
+		if tt.truncated && (!errors.As(err, &terr) || terr.Limit <= 0) {
+			t.Errorf("%s: Fetch() err = %v, want *TruncatedError", tt.name, err)
+		}
+		if res.Source != tt.source || string(res.Data) != tt.data {
+			t.Errorf("%s: Fetch() = %v %q, want %v %q", tt.name, res.Source, res.Data, tt.source, tt.data)
+		}
+		want := tt.cached
+		if !tt.truncated {
+			want = tt.body
+		}
+		if d, _ := cache.GetData(testServer, testBoard, tt.thread); string(d) != want {
+			t.Errorf("%s: cache = %q, want %q", tt.name, d, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package get2ch
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// メモリ上のキャッシュ
+// 合計サイズと件数の上限を超えると古いものから捨てる
+type MemoryCache struct {
+	maxBytes   int64 // 合計サイズの上限 0の場合は制限しない
+	maxEntries int   // 件数の上限 0の場合は制限しない
+	lru        *list.List
+	items      map[string]*list.Element
+	bytes      int64
+	stats      MemoryCacheStats
+	mux        sync.Mutex
+}
+
+type memoryEntry struct {
+	key   string
+	data  []byte
+	atime int64
+	mtime int64
+}
+
+// MemoryCacheの統計
+type MemoryCacheStats struct {
+	Hits      uint64 // GetDataで見つかった回数
+	Misses    uint64 // GetDataで見つからなかった回数
+	Evictions uint64 // 上限を超えて捨てた回数
+	Entries   int    // 現在の件数
+	Bytes     int64  // 現在の合計サイズ
+}
+
+func NewMemoryCache(maxBytes int64, maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element, 1024),
+	}
+}
+
+// FileCacheと同じ配置のキー
+func (mc *MemoryCache) Path(s, b, t string) string {
+	return cachePath("", s, b, t)
+}
+
+func (mc *MemoryCache) GetData(s, b, t string) ([]byte, error) {
+	if !validKey(t) {
+		return nil, ErrInvalidKey
+	}
+	key := mc.Path(s, b, t)
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	e, ok := mc.items[key]
+	if !ok {
+		mc.stats.Misses++
+		return nil, notExist("open", key)
+	}
+	mc.stats.Hits++
+	mc.lru.MoveToFront(e)
+	// 呼び出し側で書き換えられても影響しないよう複製する
+	return append([]byte(nil), e.Value.(*memoryEntry).data...), nil
+}
+
+func (mc *MemoryCache) SetData(s, b, t string, d []byte) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
+	key := mc.Path(s, b, t)
+	now := time.Now().Unix()
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	if e, ok := mc.items[key]; ok {
+		me := e.Value.(*memoryEntry)
+		mc.bytes += int64(len(d)) - int64(len(me.data))
+		me.data = append([]byte(nil), d...)
+		me.atime, me.mtime = now, now
+		mc.lru.MoveToFront(e)
+	} else {
+		me := &memoryEntry{
+			key:   key,
+			data:  append([]byte(nil), d...),
+			atime: now,
+			mtime: now,
+		}
+		mc.items[key] = mc.lru.PushFront(me)
+		mc.bytes += int64(len(d))
+	}
+	mc.evict()
+	return nil
+}
+
+// FileCacheと同じく存在しない場合はエラー
+func (mc *MemoryCache) SetDataAppend(s, b, t string, d []byte) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
+	key := mc.Path(s, b, t)
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	e, ok := mc.items[key]
+	if !ok {
+		return notExist("open", key)
+	}
+	me := e.Value.(*memoryEntry)
+	me.data = append(me.data, d...)
+	me.mtime = time.Now().Unix()
+	mc.bytes += int64(len(d))
+	mc.lru.MoveToFront(e)
+	mc.evict()
+	return nil
+}
+
+func (mc *MemoryCache) SetMod(s, b, t string, m, a int64) error {
+	if !validKey(t) {
+		return ErrInvalidKey
+	}
+	key := mc.Path(s, b, t)
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	e, ok := mc.items[key]
+	if !ok {
+		return notExist("chtimes", key)
+	}
+	me := e.Value.(*memoryEntry)
+	me.atime, me.mtime = a, m
+	return nil
+}
+
+func (mc *MemoryCache) Exists(s, b, t string) bool {
+	if !validKey(t) {
+		return false
+	}
+	mc.mux.Lock()
+	_, ok := mc.items[mc.Path(s, b, t)]
+	mc.mux.Unlock()
+	return ok
+}
+
+func (mc *MemoryCache) Stat(s, b, t string) (CacheState, error) {
+	if !validKey(t) {
+		return nil, ErrInvalidKey
+	}
+	key := mc.Path(s, b, t)
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	e, ok := mc.items[key]
+	if !ok {
+		return nil, notExist("stat", key)
+	}
+	me := e.Value.(*memoryEntry)
+	return &State{
+		fsize: int64(len(me.data)),
+		atime: me.atime,
+		mtime: me.mtime,
+	}, nil
+}
+
+func (mc *MemoryCache) Stats() MemoryCacheStats {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	st := mc.stats
+	st.Entries = len(mc.items)
+	st.Bytes = mc.bytes
+	return st
+}
+
+// 上限を超えている間、最も使われていないものから捨てる
+// ロックした状態で呼び出す
+func (mc *MemoryCache) evict() {
+	for mc.lru.Len() > 0 &&
+		((mc.maxBytes > 0 && mc.bytes > mc.maxBytes) || (mc.maxEntries > 0 && mc.lru.Len() > mc.maxEntries)) {
+		e := mc.lru.Back()
+		me := e.Value.(*memoryEntry)
+		mc.lru.Remove(e)
+		delete(mc.items, me.key)
+		mc.bytes -= int64(len(me.data))
+		mc.stats.Evictions++
+	}
+}
+
+// FileCacheと同じくos.ErrNotExistで判定できるエラー
+func notExist(op, key string) error {
+	return &os.PathError{Op: op, Path: key, Err: os.ErrNotExist}
+}
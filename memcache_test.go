@@ -0,0 +1,82 @@
+package get2ch
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMemoryCache(t *testing.T) {
+	mc := NewMemoryCache(0, 0)
+	if _, err := mc.GetData(testServer, testBoard, testThread); !os.IsNotExist(err) {
+		t.Errorf("GetData() of missing key = %v", err)
+	}
+	if err := mc.SetDataAppend(testServer, testBoard, testThread, []byte("x")); !os.IsNotExist(err) {
+		t.Errorf("SetDataAppend() of missing key = %v", err)
+	}
+	d := []byte("1\n")
+	if err := mc.SetData(testServer, testBoard, testThread, d); err != nil {
+		t.Fatal(err)
+	}
+	// 渡したデータを書き換えても影響しない
+	d[0] = 'x'
+	if err := mc.SetDataAppend(testServer, testBoard, testThread, []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mc.GetData(testServer, testBoard, testThread)
+	if err != nil || string(got) != "1\n2\n" {
+		t.Fatalf("GetData() = %q, %v", got, err)
+	}
+	got[0] = 'x'
+	if got, _ := mc.GetData(testServer, testBoard, testThread); string(got) != "1\n2\n" {
+		t.Errorf("GetData() after modifying result = %q", got)
+	}
+	if err := mc.SetMod(testServer, testBoard, testThread, 100, 200); err != nil {
+		t.Fatal(err)
+	}
+	st, err := mc.Stat(testServer, testBoard, testThread)
+	if err != nil || st.Size() != 4 || st.Mmod() != 100 || st.Amod() != 200 {
+		t.Errorf("Stat() = %+v, %v", st, err)
+	}
+	if !mc.Exists(testServer, testBoard, testThread) || mc.Exists(testServer, testBoard, "9999999999") {
+		t.Error("Exists() mismatch")
+	}
+	if err := mc.SetData(testServer, testBoard, "1", nil); err != ErrInvalidKey {
+		t.Errorf("SetData() with short key = %v", err)
+	}
+	s := mc.Stats()
+	if s.Hits != 2 || s.Misses != 1 || s.Entries != 1 || s.Bytes != 4 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+// 上限を超えると最も使われていないものから捨てる
+func TestMemoryCacheEvict(t *testing.T) {
+	mc := NewMemoryCache(10, 3)
+	keys := []string{"1000000001", "1000000002", "1000000003"}
+	for _, k := range keys {
+		mc.SetData(testServer, testBoard, k, []byte("123"))
+	}
+	// 1を使うと2が最も古くなる
+	mc.GetData(testServer, testBoard, keys[0])
+	mc.SetData(testServer, testBoard, "1000000004", []byte("1"))
+	if mc.Exists(testServer, testBoard, keys[1]) {
+		t.Error("least recently used entry was not evicted")
+	}
+	if !mc.Exists(testServer, testBoard, keys[0]) || !mc.Exists(testServer, testBoard, keys[2]) {
+		t.Error("recently used entry was evicted")
+	}
+	// 合計サイズの上限
+	mc.SetDataAppend(testServer, testBoard, keys[0], []byte("12345"))
+	s := mc.Stats()
+	if s.Bytes > 10 {
+		t.Errorf("Bytes = %d, want <= 10", s.Bytes)
+	}
+	if s.Evictions != 2 {
+		t.Errorf("Evictions = %d, want 2", s.Evictions)
+	}
+	// 上限を超える1件は残らない
+	mc.SetData(testServer, testBoard, "1000000005", make([]byte, 11))
+	if s := mc.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("Stats() after oversized entry = %+v", s)
+	}
+}
@@ -0,0 +1,251 @@
+package get2ch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const MENU_VERSION = 1 // ita.dataの形式
+
+// 板の情報
+type MenuBoard struct {
+	Server   string `json:"server"`
+	Board    string `json:"board"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// カテゴリと所属する板
+type MenuCategory struct {
+	Name   string      `json:"name"`
+	Boards []MenuBoard `json:"boards"`
+}
+
+// 板一覧
+// ita.dataにはJSONで保存する
+type Menu struct {
+	Version    int            `json:"version"`
+	Categories []MenuCategory `json:"categories"`
+	index      map[string]MenuBoard
+}
+
+func newMenu() *Menu {
+	return &Menu{
+		Version:    MENU_VERSION,
+		Categories: make([]MenuCategory, 0, 64),
+	}
+}
+
+// bbsmenu.html(SJIS-win)を解析する
+func (c *Client) parseBBSmenu(d []byte) *Menu {
+	catekill, sabakill := c.catekill, c.sabakill
+	m := newMenu()
+	ci := -1 // 現在のカテゴリ 除外中は-1
+	scanner := bufio.NewScanner(c.codec.NewReader(bytes.NewReader(d)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := RegServerItem.FindStringSubmatch(line); match != nil {
+			// 当てはまるものを除外
+			if _, ok := catekill[match[1]]; ok {
+				ci = -1
+			} else {
+				m.Categories = append(m.Categories, MenuCategory{Name: match[1]})
+				ci = len(m.Categories) - 1
+			}
+		} else if ci < 0 {
+			continue
+		} else if strings.Contains(line, ".2ch.net/") || strings.Contains(line, ".bbspink.com/") {
+			if strings.Contains(line, "TARGET") {
+				continue
+			}
+			if match := RegServer.FindStringSubmatch(line); match != nil {
+				if _, ok := sabakill[match[1]]; ok {
+					continue
+				}
+				cate := &m.Categories[ci]
+				cate.Boards = append(cate.Boards, MenuBoard{
+					Server:   match[1],
+					Board:    match[2],
+					Name:     match[3],
+					Category: cate.Name,
+				})
+			}
+		}
+	}
+	m.build()
+	return m
+}
+
+// JSONで保存されているか
+func isMenuJSON(data []byte) bool {
+	d := bytes.TrimSpace(data)
+	return len(d) > 0 && d[0] == '{'
+}
+
+// ita.dataを読み込む
+// 以前のテキスト形式も読める
+func decodeMenu(data []byte) (*Menu, error) {
+	if isMenuJSON(data) {
+		d := bytes.TrimSpace(data)
+		m := &Menu{}
+		if err := json.Unmarshal(d, m); err != nil {
+			return nil, err
+		}
+		if m.Version != MENU_VERSION {
+			return nil, ErrMenuVersion
+		}
+		m.build()
+		return m, nil
+	}
+	return decodeLegacyMenu(data), nil
+}
+
+// 「カテゴリ名」と「server/board<>板名」が並んだ形式
+func decodeLegacyMenu(data []byte) *Menu {
+	m := newMenu()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		sp := strings.SplitN(line, "<>", 2)
+		if len(sp) < 2 {
+			if line != "" {
+				m.Categories = append(m.Categories, MenuCategory{Name: line})
+			}
+			continue
+		}
+		u := strings.SplitN(sp[0], "/", 2)
+		if len(u) < 2 {
+			continue
+		}
+		if len(m.Categories) == 0 {
+			// カテゴリより前に板がある場合
+			m.Categories = append(m.Categories, MenuCategory{})
+		}
+		cate := &m.Categories[len(m.Categories)-1]
+		cate.Boards = append(cate.Boards, MenuBoard{
+			Server:   u[0],
+			Board:    u[1],
+			Name:     sp[1],
+			Category: cate.Name,
+		})
+	}
+	m.build()
+	return m
+}
+
+func (m *Menu) encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// decodeLegacyMenuで読める形式にする
+func (m *Menu) encodeLegacy() []byte {
+	data := bytes.Buffer{}
+	for _, cate := range m.Categories {
+		if cate.Name != "" {
+			data.WriteString(cate.Name + "\n")
+		}
+		for _, b := range cate.Boards {
+			data.WriteString(b.Server + "/" + b.Board + "<>" + b.Name + "\n")
+		}
+	}
+	return data.Bytes()
+}
+
+// 板名から引けるようにする
+// 同じ板が複数ある場合は先に出てきたものを使う
+func (m *Menu) build() {
+	m.index = make(map[string]MenuBoard, 1024)
+	for _, cate := range m.Categories {
+		for _, b := range cate.Boards {
+			if _, ok := m.index[b.Board]; !ok {
+				m.index[b.Board] = b
+			}
+		}
+	}
+}
+
+func (m *Menu) ListCategories() []string {
+	list := make([]string, 0, len(m.Categories))
+	for _, cate := range m.Categories {
+		list = append(list, cate.Name)
+	}
+	return list
+}
+
+// カテゴリに所属する板を返す
+// categoryが空の場合は全ての板
+func (m *Menu) ListBoards(category string) []MenuBoard {
+	list := make([]MenuBoard, 0, 64)
+	for _, cate := range m.Categories {
+		if category == "" || cate.Name == category {
+			list = append(list, cate.Boards...)
+		}
+	}
+	return list
+}
+
+// 隠し板も探す
+func (m *Menu) LookupBoard(board string) (MenuBoard, bool) {
+	if b, ok := m.index[board]; ok {
+		return b, true
+	}
+	if it, ok := hideboard[board]; ok {
+		return MenuBoard{
+			Server: it.server,
+			Board:  board,
+			Name:   it.name,
+		}, true
+	}
+	return MenuBoard{}, false
+}
+
+// 現在の板一覧
+func (c *Client) Menu() *Menu {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.menu
+}
+
+func (c *Client) ListCategories() []string {
+	return c.Menu().ListCategories()
+}
+
+func (c *Client) ListBoards(category string) []MenuBoard {
+	return c.Menu().ListBoards(category)
+}
+
+func (c *Client) LookupBoard(board string) (MenuBoard, bool) {
+	return c.Menu().LookupBoard(board)
+}
+
+// 保存済みの板一覧を読み込む
+// 以前のテキスト形式の場合はJSONに書き換える(更新時間は変えない)
+func (c *Client) loadMenu(cache Cache) *Menu {
+	if cache == nil {
+		return nil
+	}
+	data, err := cache.GetData("", "", "")
+	if err != nil {
+		return nil
+	}
+	m, err := decodeMenu(data)
+	if err != nil {
+		return nil
+	}
+	if !isMenuJSON(data) {
+		if st, serr := cache.Stat("", "", ""); serr == nil {
+			if d, eerr := m.encode(); eerr == nil && cache.SetData("", "", "", d) == nil {
+				cache.SetMod("", "", "", st.Mmod(), st.Amod())
+			}
+		}
+	}
+	return m
+}
+
+func (c *Client) setMenu(m *Menu) {
+	c.mux.Lock()
+	c.menu = m
+	c.mux.Unlock()
+}
@@ -0,0 +1,91 @@
+package get2ch
+
+import (
+	"context"
+	"testing"
+)
+
+const legacyMenu = "ニュース\nnews.2ch.net/newsplus<>ニュース速報+\nnews.2ch.net/news<>ニュース速報\n雑談\nhayabusa.2ch.net/livejupiter<>なんでも実況J\n"
+
+func TestDecodeMenu(t *testing.T) {
+	m, err := decodeMenu([]byte(legacyMenu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.ListCategories(); len(got) != 2 || got[0] != "ニュース" || got[1] != "雑談" {
+		t.Errorf("ListCategories() = %v", got)
+	}
+	if got := m.ListBoards("ニュース"); len(got) != 2 {
+		t.Errorf("ListBoards() = %v", got)
+	}
+	b, ok := m.LookupBoard("livejupiter")
+	if !ok || b.Server != "hayabusa.2ch.net" || b.Category != "雑談" {
+		t.Errorf("LookupBoard() = %v, %v", b, ok)
+	}
+
+	// JSONに変換して読み直しても変わらない
+	d, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := decodeMenu(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b2, ok := m2.LookupBoard("livejupiter"); !ok || b2 != b {
+		t.Errorf("LookupBoard() = %v, %v", b2, ok)
+	}
+
+	if _, err := decodeMenu([]byte(`{"version":999}`)); err != ErrMenuVersion {
+		t.Errorf("decodeMenu() err = %v, want ErrMenuVersion", err)
+	}
+}
+
+// 以前の形式のita.dataは以前の形式で返し、JSONで保存し直す
+func TestGetBBSmenuLegacy(t *testing.T) {
+	cache := NewMemoryCache(0, 0)
+	if err := cache.SetData("", "", "", []byte(legacyMenu)); err != nil {
+		t.Fatal(err)
+	}
+	const mod = 1400000000
+	cache.SetMod("", "", "", mod, mod)
+	g2ch := &Get2ch{cache: cache, client: &Client{}}
+
+	data, err := g2ch.GetBBSmenuContext(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != legacyMenu {
+		t.Fatalf("GetBBSmenuContext() = %q, want %q", data, legacyMenu)
+	}
+	if g2ch.mod != mod {
+		t.Errorf("mod = %d, want %d", g2ch.mod, mod)
+	}
+	saved, err := cache.GetData("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMenuJSON(saved) {
+		t.Errorf("ita.data was not migrated: %q", saved)
+	}
+	if st, err := cache.Stat("", "", ""); err != nil || st.Mmod() != mod {
+		t.Errorf("Stat() = %v, %v, want mod %d", st, err, mod)
+	}
+}
+
+func TestGetMenu(t *testing.T) {
+	cache := NewMemoryCache(0, 0)
+	cache.SetData("", "", "", []byte(legacyMenu))
+	g2ch := &Get2ch{cache: cache, client: &Client{}}
+	m, err := g2ch.GetMenuContext(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := m.LookupBoard("newsplus"); !ok || b.Server != "news.2ch.net" || b.Category != "ニュース" {
+		t.Errorf("LookupBoard() = %v, %v", b, ok)
+	}
+	// JSONで保存した後も以前の形式で返す
+	if data := g2ch.GetBBSmenu(false); string(data) != legacyMenu {
+		t.Errorf("GetBBSmenu() = %q", data)
+	}
+}
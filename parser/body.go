@@ -0,0 +1,196 @@
+package parser
+
+// 本文の分解と出力
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	ANCHOR_HREF_PREFIX = "#res" // HTML/Markdownでのアンカーのリンク先
+	BE_ICON_SCHEME     = "sssp://"
+)
+
+// 字句の種類
+type TokenKind int
+
+const (
+	TOKEN_TEXT    TokenKind = iota // 文字列(実体参照は展開済み)
+	TOKEN_NEWLINE                  // <br>
+	TOKEN_ANCHOR                   // >>1-5等
+	TOKEN_URL                      // URL
+	TOKEN_BE_ICON                  // BEのアイコン
+)
+
+// アンカーの範囲 単独の場合はFromとToが同じ
+type AnchorRange struct {
+	From int
+	To   int
+}
+
+// 本文の字句
+type Token struct {
+	Kind   TokenKind
+	Text   string        // 本文での表記
+	URL    string        // URLとBEアイコンの補完済みのURL
+	Ranges []AnchorRange // アンカーの参照先
+}
+
+var regBodyTag = regexp.MustCompile(`(?i)\s?<br\s*/?>\s?|<[^>]*>`)
+var regBodyLink = regexp.MustCompile(`(?:>>?|＞＞?)((?:[0-9０-９]+(?:[-－ー][0-9０-９]+)?)(?:[,、，][0-9０-９]+(?:[-－ー][0-9０-９]+)?)*)|(?:h?t?tps?|sssp)://[-_.!~*'()a-zA-Z0-9;/?:@&=+$,%#]+`)
+var numReplacer = strings.NewReplacer(
+	"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+	"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+	"－", "-", "ー", "-", "、", ",", "，", ",",
+)
+
+// 本文を字句に分ける
+func TokenizeBody(body string) []Token {
+	list := make([]Token, 0, 16)
+	pos := 0
+	for _, m := range regBodyTag.FindAllStringIndex(body, -1) {
+		list = appendText(list, body[pos:m[0]])
+		if strings.Contains(strings.ToLower(body[m[0]:m[1]]), "<br") {
+			list = append(list, Token{Kind: TOKEN_NEWLINE, Text: "\n"})
+		}
+		// <a>等のタグは外して中身だけ使う
+		pos = m[1]
+	}
+	return appendText(list, body[pos:])
+}
+
+// 本文を字句に分ける
+func (r Res) Tokens() []Token {
+	return TokenizeBody(r.Body)
+}
+
+// 実体参照を展開してアンカーとURLを取り出す
+func appendText(list []Token, s string) []Token {
+	if s == "" {
+		return list
+	}
+	s = html.UnescapeString(s)
+	pos := 0
+	for _, m := range regBodyLink.FindAllStringSubmatchIndex(s, -1) {
+		var tok Token
+		text := s[m[0]:m[1]]
+		if m[2] >= 0 {
+			tok = Token{Kind: TOKEN_ANCHOR, Text: text, Ranges: parseAnchor(s[m[2]:m[3]])}
+			if len(tok.Ranges) == 0 {
+				continue
+			}
+		} else if strings.HasPrefix(text, BE_ICON_SCHEME) {
+			tok = Token{Kind: TOKEN_BE_ICON, Text: text, URL: "http://" + text[len(BE_ICON_SCHEME):]}
+		} else {
+			tok = Token{Kind: TOKEN_URL, Text: text, URL: completeURL(text)}
+		}
+		if pos < m[0] {
+			list = append(list, Token{Kind: TOKEN_TEXT, Text: s[pos:m[0]]})
+		}
+		list = append(list, tok)
+		pos = m[1]
+	}
+	if pos < len(s) {
+		list = append(list, Token{Kind: TOKEN_TEXT, Text: s[pos:]})
+	}
+	return list
+}
+
+// 「1-5,7」の形式
+func parseAnchor(s string) []AnchorRange {
+	list := make([]AnchorRange, 0, 1)
+	for _, it := range strings.Split(numReplacer.Replace(s), ",") {
+		sp := strings.SplitN(it, "-", 2)
+		from, err := strconv.Atoi(sp[0])
+		if err != nil || from <= 0 {
+			continue
+		}
+		to := from
+		if len(sp) == 2 {
+			if to, err = strconv.Atoi(sp[1]); err != nil || to < from {
+				to = from
+			}
+		}
+		list = append(list, AnchorRange{From: from, To: to})
+	}
+	return list
+}
+
+// 「ttp://」等の省略されたURLを補う
+func completeURL(s string) string {
+	i := strings.Index(s, "://")
+	switch scheme := s[:i]; {
+	case strings.HasSuffix(scheme, "ps"):
+		return "https" + s[i:]
+	default:
+		return "http" + s[i:]
+	}
+}
+
+// 文字列にする
+func RenderText(list []Token) string {
+	var b strings.Builder
+	for _, tok := range list {
+		switch tok.Kind {
+		case TOKEN_BE_ICON:
+			// アイコンは出力しない
+		default:
+			b.WriteString(tok.Text)
+		}
+	}
+	return b.String()
+}
+
+// 安全なHTMLにする
+// タグはアンカー、URL、改行、アイコン以外出力しない
+func RenderHTML(list []Token) string {
+	var b strings.Builder
+	for _, tok := range list {
+		switch tok.Kind {
+		case TOKEN_TEXT:
+			b.WriteString(html.EscapeString(tok.Text))
+		case TOKEN_NEWLINE:
+			b.WriteString("<br>")
+		case TOKEN_ANCHOR:
+			b.WriteString(`<a href="` + ANCHOR_HREF_PREFIX + strconv.Itoa(tok.Ranges[0].From) + `" class="anchor">`)
+			b.WriteString(html.EscapeString(tok.Text))
+			b.WriteString("</a>")
+		case TOKEN_URL:
+			b.WriteString(`<a href="` + html.EscapeString(tok.URL) + `" rel="nofollow noopener" target="_blank">`)
+			b.WriteString(html.EscapeString(tok.Text))
+			b.WriteString("</a>")
+		case TOKEN_BE_ICON:
+			b.WriteString(`<img src="` + html.EscapeString(tok.URL) + `" alt="">`)
+		}
+	}
+	return b.String()
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+// Markdownにする
+func RenderMarkdown(list []Token) string {
+	var b strings.Builder
+	for _, tok := range list {
+		switch tok.Kind {
+		case TOKEN_TEXT:
+			b.WriteString(markdownReplacer.Replace(tok.Text))
+		case TOKEN_NEWLINE:
+			// 行末の空白2つで改行
+			b.WriteString("  \n")
+		case TOKEN_ANCHOR:
+			b.WriteString("[" + markdownReplacer.Replace(tok.Text) + "](" + ANCHOR_HREF_PREFIX + strconv.Itoa(tok.Ranges[0].From) + ")")
+		case TOKEN_URL:
+			b.WriteString("<" + tok.URL + ">")
+		case TOKEN_BE_ICON:
+			b.WriteString("![](" + tok.URL + ")")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeBody(t *testing.T) {
+	body := `&gt;&gt;1-3,５ テスト <br> <a href="../test/read.cgi/news/1/2" target="_blank">&gt;&gt;2</a> ttp://example.com/a?b=1&amp;c=2 sssp://img.2ch.net/ico/a.gif &lt;b&gt;`
+	got := TokenizeBody(body)
+	want := []Token{
+		{Kind: TOKEN_ANCHOR, Text: ">>1-3,５", Ranges: []AnchorRange{{1, 3}, {5, 5}}},
+		{Kind: TOKEN_TEXT, Text: " テスト"},
+		{Kind: TOKEN_NEWLINE, Text: "\n"},
+		{Kind: TOKEN_ANCHOR, Text: ">>2", Ranges: []AnchorRange{{2, 2}}},
+		{Kind: TOKEN_TEXT, Text: " "},
+		{Kind: TOKEN_URL, Text: "ttp://example.com/a?b=1&c=2", URL: "http://example.com/a?b=1&c=2"},
+		{Kind: TOKEN_TEXT, Text: " "},
+		{Kind: TOKEN_BE_ICON, Text: "sssp://img.2ch.net/ico/a.gif", URL: "http://img.2ch.net/ico/a.gif"},
+		{Kind: TOKEN_TEXT, Text: " <b>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TokenizeBody() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseAnchor(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []AnchorRange
+	}{
+		{"1", []AnchorRange{{1, 1}}},
+		{"１０－２０", []AnchorRange{{10, 20}}},
+		{"5-3", []AnchorRange{{5, 5}}},
+		{"1、2，3", []AnchorRange{{1, 1}, {2, 2}, {3, 3}}},
+		{"0", []AnchorRange{}},
+	}
+	for _, tt := range tests {
+		if got := parseAnchor(tt.src); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAnchor(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+	if got := TokenizeBody("&gt;&gt;0"); len(got) != 1 || got[0].Kind != TOKEN_TEXT {
+		t.Errorf("TokenizeBody(>>0) = %+v", got)
+	}
+}
+
+func TestCompleteURL(t *testing.T) {
+	tests := map[string]string{
+		"http://a/":  "http://a/",
+		"ttp://a/":   "http://a/",
+		"tp://a/":    "http://a/",
+		"https://a/": "https://a/",
+		"ttps://a/":  "https://a/",
+	}
+	for src, want := range tests {
+		if got := completeURL(src); got != want {
+			t.Errorf("completeURL(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+// URLは「"」の手前まで
+func TestRender(t *testing.T) {
+	list := TokenizeBody(`&gt;&gt;1 a_b <br> &lt;script&gt; http://example.com/"x sssp://img.2ch.net/ico/a.gif`)
+	if got, want := RenderText(list), ">>1 a_b\n<script> http://example.com/\"x "; got != want {
+		t.Errorf("RenderText() = %q, want %q", got, want)
+	}
+	wantHTML := `<a href="#res1" class="anchor">&gt;&gt;1</a> a_b<br>&lt;script&gt; ` +
+		`<a href="http://example.com/" rel="nofollow noopener" target="_blank">http://example.com/</a>&#34;x ` +
+		`<img src="http://img.2ch.net/ico/a.gif" alt="">`
+	if got := RenderHTML(list); got != wantHTML {
+		t.Errorf("RenderHTML() =\n%s\nwant\n%s", got, wantHTML)
+	}
+	wantMD := `[\>\>1](#res1) a\_b` + "  \n" + `\<script\> <http://example.com/>"x ![](http://img.2ch.net/ico/a.gif)`
+	if got := RenderMarkdown(list); got != wantMD {
+		t.Errorf("RenderMarkdown() =\n%s\nwant\n%s", got, wantMD)
+	}
+}
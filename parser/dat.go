@@ -0,0 +1,188 @@
+package parser
+
+// datの解析
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/tanaton/get2ch-go/internal/codec"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LINE_MAX_SIZE = 1024 * 1024 // 1行の最大サイズ
+	DAT_SEPARATOR = "<>"
+	TRIP_MARK     = "◆"
+)
+
+// 入力の文字コード
+type Encoding int
+
+const (
+	ENC_AUTO Encoding = iota // 行毎に判定する
+	ENC_SJIS                 // SJIS-win
+	ENC_UTF8
+)
+
+// 1レス分のデータ
+// 文字列は全てUTF-8
+type Res struct {
+	Number int    // レス番号(1から)
+	Name   string // 名前欄(トリップを除く)
+	Trip   string // トリップ(◆を除く)
+	Mail   string // メール欄
+	Date   string // 日付欄そのまま
+	Body   string // 本文(HTMLのまま)
+	Title  string // スレッドタイトル(1のみ)
+	Broken bool   // 形式が壊れている場合true
+}
+
+// SJIS-winからUTF-8への変換
+// get2chのClientと同じ設定で変換する場合に渡す
+type Decoder interface {
+	Decode(src []byte) ([]byte, error)
+}
+
+// datを1レスずつ読む
+type Scanner struct {
+	sc  *bufio.Scanner
+	enc Encoding
+	dec Decoder
+	num int
+	res Res
+	err error
+}
+
+func NewScanner(r io.Reader, enc Encoding) *Scanner {
+	return NewScannerFrom(r, enc, 1)
+}
+
+// 途中から読む場合
+// firstは最初の行のレス番号
+func NewScannerFrom(r io.Reader, enc Encoding, first int) *Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), LINE_MAX_SIZE)
+	return &Scanner{
+		sc:  sc,
+		enc: enc,
+		num: first - 1,
+	}
+}
+
+// SJIS-winの行の変換方法を変える
+// nilの場合は変換できないバイトを置換する
+func (s *Scanner) SetDecoder(dec Decoder) {
+	s.dec = dec
+}
+
+// 次のレスを読む
+// 壊れた行もレス番号を合わせるため返す
+// 変換に失敗した場合はそこで止まる
+func (s *Scanner) Scan() bool {
+	if s.err != nil || !s.sc.Scan() {
+		return false
+	}
+	line, err := decodeLine(s.sc.Bytes(), s.enc, s.dec)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	s.num++
+	s.res = ParseLine(line, s.num)
+	return true
+}
+
+func (s *Scanner) Res() Res {
+	return s.res
+}
+
+func (s *Scanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.sc.Err()
+}
+
+// dat全体を解析する
+func Parse(data []byte, enc Encoding) []Res {
+	return ParseFrom(data, enc, 1)
+}
+
+// 差分等、datの途中からを解析する
+func ParseFrom(data []byte, enc Encoding, first int) []Res {
+	list, _ := ParseFromDecoder(data, enc, nil, first)
+	return list
+}
+
+// decで変換する
+// 変換に失敗した場合は失敗した行の前までとエラーを返す
+func ParseFromDecoder(data []byte, enc Encoding, dec Decoder, first int) ([]Res, error) {
+	list := make([]Res, 0, bytes.Count(data, []byte{'\n'})+1)
+	s := NewScannerFrom(bytes.NewReader(data), enc, first)
+	s.SetDecoder(dec)
+	for s.Scan() {
+		list = append(list, s.Res())
+	}
+	return list, s.Err()
+}
+
+// 指定が無い場合は変換できないバイトを置換する
+var sjis Decoder = codec.NewCP932(codec.MODE_REPLACE)
+
+func decodeLine(line []byte, enc Encoding, dec Decoder) (string, error) {
+	if enc == ENC_UTF8 || (enc == ENC_AUTO && utf8.Valid(line)) {
+		return string(line), nil
+	}
+	if dec == nil {
+		dec = sjis
+	}
+	d, err := dec.Decode(line)
+	return string(d), err
+}
+
+// UTF-8の1行を解析する
+// 「名前<>メール<>日付<>本文<>タイトル」の形式
+func ParseLine(line string, num int) Res {
+	line = strings.TrimRight(line, "\r\n")
+	res := Res{Number: num}
+	sp := strings.Split(line, DAT_SEPARATOR)
+	if len(sp) < 4 {
+		// 壊れている場合は本文に全部入れる
+		res.Body = line
+		res.Broken = true
+		return res
+	}
+	res.Name, res.Trip = splitTrip(sp[0])
+	res.Mail = sp[1]
+	res.Date = sp[2]
+	res.Body = strings.TrimSpace(sp[3])
+	if num == 1 && len(sp) > 4 {
+		res.Title = strings.TrimSpace(sp[4])
+	}
+	return res
+}
+
+// 名前欄からトリップを分ける
+// 「名無し </b>◆abcdefghij <b>」の形式
+func splitTrip(field string) (name, trip string) {
+	i := strings.Index(field, TRIP_MARK)
+	if i < 0 {
+		return strings.TrimSpace(field), ""
+	}
+	name = trimBold(field[:i])
+	trip = field[i+len(TRIP_MARK):]
+	if j := strings.Index(trip, "<b>"); j >= 0 {
+		trip = trip[:j]
+	}
+	trip = strings.TrimSpace(trip)
+	return
+}
+
+func trimBold(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "</b>")
+	s = strings.TrimPrefix(s, "<b>")
+	return strings.TrimSpace(s)
+}
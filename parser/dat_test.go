@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"bytes"
+	"github.com/tanaton/get2ch-go/internal/codec"
+	"strings"
+	"testing"
+)
+
+const testDat = "名無しさん<>sage<>2014/01/01(水) 00:00:00.00 ID:abcdefgh<> 本文1 <br> 二行目 <>スレタイ\n" +
+	"<b>名無し</b>◆Trip12345 <b><><>2014/01/01(水) 00:00:01.00 ID:ijklmnop<> &gt;&gt;1 乙 <>\n" +
+	"壊れた行\n"
+
+func TestParse(t *testing.T) {
+	list := Parse([]byte(testDat), ENC_UTF8)
+	if len(list) != 3 {
+		t.Fatalf("len = %d, want 3", len(list))
+	}
+	want := []Res{
+		{
+			Number: 1,
+			Name:   "名無しさん",
+			Mail:   "sage",
+			Date:   "2014/01/01(水) 00:00:00.00 ID:abcdefgh",
+			Body:   "本文1 <br> 二行目",
+			Title:  "スレタイ",
+		},
+		{
+			Number: 2,
+			Name:   "名無し",
+			Trip:   "Trip12345",
+			Date:   "2014/01/01(水) 00:00:01.00 ID:ijklmnop",
+			Body:   "&gt;&gt;1 乙",
+		},
+		{
+			Number: 3,
+			Body:   "壊れた行",
+			Broken: true,
+		},
+	}
+	for i := range want {
+		if list[i] != want[i] {
+			t.Errorf("res %d = %+v, want %+v", i+1, list[i], want[i])
+		}
+	}
+}
+
+// 差分はレス番号を続きから振る
+func TestParseFrom(t *testing.T) {
+	list := ParseFrom([]byte("a<>b<>c<>d<>\na<>b<>c<>d<>\n"), ENC_UTF8, 101)
+	if len(list) != 2 || list[0].Number != 101 || list[1].Number != 102 {
+		t.Errorf("ParseFrom() = %+v", list)
+	}
+	// タイトルは1のみ
+	if list[0].Title != "" {
+		t.Errorf("Title = %q", list[0].Title)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	// 「名無し<>sage<>日付<>本文<>」をSJISで
+	sjis := []byte("\x96\xbc\x96\xb3\x82\xb5<>sage<>\x93\xfa\x95\x74<>\x96\x7b\x95\xb6<>\n")
+	for _, enc := range []Encoding{ENC_SJIS, ENC_AUTO} {
+		list := Parse(sjis, enc)
+		if len(list) != 1 || list[0].Name != "名無し" || list[0].Body != "本文" {
+			t.Errorf("enc %d: Parse() = %+v", enc, list)
+		}
+	}
+	// UTF-8の行はそのまま
+	if list := Parse([]byte("名無し<><><>本文<>\n"), ENC_AUTO); list[0].Name != "名無し" {
+		t.Errorf("ENC_AUTO utf8: Parse() = %+v", list)
+	}
+}
+
+// 渡した変換方法で変換し、失敗した場合は手前の行までとエラーを返す
+func TestParseFromDecoder(t *testing.T) {
+	// 2行目に不正なバイト(0x81 0x20)を含む
+	data := []byte("a<><><>1<>\nb<><><>\x81 <>\nc<><><>3<>\n")
+	list, err := ParseFromDecoder(data, ENC_SJIS, codec.NewCP932(codec.MODE_ERROR), 5)
+	if err != codec.ErrInvalid || len(list) != 1 || list[0].Number != 5 {
+		t.Errorf("ParseFromDecoder() = %+v, %v", list, err)
+	}
+	// 指定しない場合は置換する
+	list, err = ParseFromDecoder(data, ENC_SJIS, nil, 5)
+	if err != nil || len(list) != 3 || list[1].Body != "\uFFFD" {
+		t.Errorf("ParseFromDecoder() = %+v, %v", list, err)
+	}
+	// 元のバイト列を保持する
+	list, _ = ParseFromDecoder(data, ENC_SJIS, codec.NewCP932(codec.MODE_RAW), 5)
+	if len(list) != 3 || list[1].Body != string(rune(codec.RAW_BASE+0x81)) {
+		t.Errorf("ParseFromDecoder() MODE_RAW = %+v", list)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	s := NewScanner(strings.NewReader(testDat), ENC_UTF8)
+	n := 0
+	for s.Scan() {
+		n++
+		if s.Res().Number != n {
+			t.Errorf("Number = %d, want %d", s.Res().Number, n)
+		}
+	}
+	if err := s.Err(); err != nil || n != 3 {
+		t.Errorf("Scan() = %d res, %v", n, err)
+	}
+
+	// 1行の上限を超えた場合はエラー
+	long := bytes.Repeat([]byte("a"), LINE_MAX_SIZE+1)
+	s = NewScanner(bytes.NewReader(long), ENC_UTF8)
+	for s.Scan() {
+	}
+	if s.Err() == nil {
+		t.Error("Err() = nil for too long line")
+	}
+}
@@ -0,0 +1,123 @@
+package parser
+
+// 日付欄の解析
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ABONE_MARK   = "あぼーん"
+	ID_PREFIX    = "ID:"
+	BE_PREFIX    = "BE:"
+	ID_UNKNOWN   = "???" // ID非表示の板等
+	YEAR_CENTURY = 90    // 2桁の年がこれ未満なら2000年代
+)
+
+// 2chの時刻は日本時間
+var JST = time.FixedZone("JST", 9*60*60)
+
+// 「2013/05/01(水) 12:34:56.78」「13/05/01 12:34」「2013年05月01日 12:34:56」等
+var regDate = regexp.MustCompile(`(\d{2,4})[/年](\d{1,2})[/月](\d{1,2})日?\s*(?:\([^)]*\))?\s*(\d{1,2}):(\d{2})(?::(\d{2})(?:\.(\d{1,9}))?)?`)
+var regBe = regexp.MustCompile(`^(\d+)-(.*)$`)
+var fieldReplacer = strings.NewReplacer("　", " ", "（", "(", "）", ")")
+
+// 日付欄の内容
+type DateField struct {
+	Time        time.Time // 投稿時間(JST) 読めなかった場合はゼロ値
+	ID          string    // 「ID:」以降 無い場合は空
+	BeID        int       // BEの会員番号 無い場合は0
+	BeRank      string    // 「2BP(1000)」等
+	Deleted     bool      // あぼーん等で消されている場合true
+	NonStandard bool      // 日付が読めなかった場合true
+	Raw         string    // 日付欄そのまま
+}
+
+// 日付欄を解析する
+func ParseDate(field string) DateField {
+	df := DateField{Raw: field}
+	field = strings.TrimSpace(field)
+	if field == "" || strings.Contains(field, ABONE_MARK) {
+		df.Deleted = true
+		df.NonStandard = true
+		return df
+	}
+	// 全角の空白と括弧は半角として扱う
+	field = fieldReplacer.Replace(field)
+	if t, ok := parseTime(field); ok {
+		df.Time = t
+	} else {
+		df.NonStandard = true
+	}
+	for _, it := range strings.Fields(field) {
+		switch {
+		case strings.HasPrefix(it, ID_PREFIX):
+			df.ID = it[len(ID_PREFIX):]
+		case strings.HasPrefix(it, BE_PREFIX):
+			df.BeID, df.BeRank = parseBe(it[len(BE_PREFIX):])
+		}
+	}
+	return df
+}
+
+// 日付欄を解析する
+func (r Res) DateField() DateField {
+	return ParseDate(r.Date)
+}
+
+// IDが表示されているか
+func (df DateField) HasID() bool {
+	return df.ID != "" && df.ID != ID_UNKNOWN
+}
+
+func parseTime(field string) (time.Time, bool) {
+	m := regDate.FindStringSubmatch(field)
+	if m == nil {
+		return time.Time{}, false
+	}
+	var n [6]int
+	for i := range n {
+		if m[i+1] == "" {
+			// 秒が無い場合
+			continue
+		}
+		v, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		n[i] = v
+	}
+	year := n[0]
+	if len(m[1]) == 2 {
+		if year < YEAR_CENTURY {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	// 小数部は桁数に合わせてナノ秒にする
+	nsec := 0
+	if frac := m[7]; frac != "" {
+		nsec, _ = strconv.Atoi(frac + strings.Repeat("0", 9-len(frac)))
+	}
+	if n[1] < 1 || n[1] > 12 || n[2] < 1 || n[2] > 31 || n[3] > 23 || n[4] > 59 || n[5] > 60 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(n[1]), n[2], n[3], n[4], n[5], nsec, JST), true
+}
+
+// 「123456789-2BP(1000)」の形式
+func parseBe(s string) (int, string) {
+	m := regBe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, s
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, s
+	}
+	return id, m[2]
+}
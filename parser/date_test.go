@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		field string
+		want  DateField
+	}{
+		{
+			"2013/05/01(水) 12:34:56.78 ID:abcdefgh0",
+			DateField{Time: time.Date(2013, 5, 1, 12, 34, 56, 780000000, JST), ID: "abcdefgh0"},
+		},
+		{
+			"13/05/01 12:34 ID:???",
+			DateField{Time: time.Date(2013, 5, 1, 12, 34, 0, 0, JST), ID: ID_UNKNOWN},
+		},
+		{
+			"99/12/31 23:59:59",
+			DateField{Time: time.Date(1999, 12, 31, 23, 59, 59, 0, JST)},
+		},
+		{
+			"2013年05月01日 12:34:56　ID:xyz BE:123456789-2BP(1000)",
+			DateField{Time: time.Date(2013, 5, 1, 12, 34, 56, 0, JST), ID: "xyz", BeID: 123456789, BeRank: "2BP(1000)"},
+		},
+		{
+			"2013/05/01（水）12:34:56",
+			DateField{Time: time.Date(2013, 5, 1, 12, 34, 56, 0, JST)},
+		},
+		{
+			"2013/13/01 12:34:56 ID:abc",
+			DateField{ID: "abc", NonStandard: true},
+		},
+		{
+			"Over 1000 Thread",
+			DateField{NonStandard: true},
+		},
+		{
+			"あぼーん",
+			DateField{Deleted: true, NonStandard: true},
+		},
+		{
+			"",
+			DateField{Deleted: true, NonStandard: true},
+		},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.field
+		got := ParseDate(tt.field)
+		if !got.Time.Equal(tt.want.Time) || got.ID != tt.want.ID || got.BeID != tt.want.BeID ||
+			got.BeRank != tt.want.BeRank || got.Deleted != tt.want.Deleted ||
+			got.NonStandard != tt.want.NonStandard || got.Raw != tt.want.Raw {
+			t.Errorf("ParseDate(%q) = %+v, want %+v", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestHasID(t *testing.T) {
+	if !ParseDate("2013/05/01 12:34 ID:abc").HasID() {
+		t.Error("HasID() = false")
+	}
+	if ParseDate("2013/05/01 12:34 ID:???").HasID() || ParseDate("2013/05/01 12:34").HasID() {
+		t.Error("HasID() = true")
+	}
+	r := Res{Date: "2013/05/01 12:34 ID:abc"}
+	if r.DateField().ID != "abc" {
+		t.Errorf("Res.DateField() = %+v", r.DateField())
+	}
+}
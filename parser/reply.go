@@ -0,0 +1,150 @@
+package parser
+
+// アンカーによる返信関係
+
+import (
+	"sort"
+)
+
+const (
+	REPLY_RANGE_MAX = 100   // 範囲アンカーで展開する最大数
+	REPLY_MAX_RES   = 10000 // これより大きいレス番号への参照は無視する
+)
+
+// 返信関係
+// レスを追加する毎に更新する
+type ReplyGraph struct {
+	refs    [][]int // refs[n]はレスnが参照しているレス番号
+	replies [][]int // replies[n]はレスnを参照しているレス番号
+	last    int     // 追加済みの最大レス番号
+}
+
+// 返信ツリーの節
+type ReplyNode struct {
+	Number   int
+	Children []*ReplyNode
+}
+
+func NewReplyGraph() *ReplyGraph {
+	return &ReplyGraph{
+		refs:    make([][]int, 1, 1024),
+		replies: make([][]int, 1, 1024),
+	}
+}
+
+// スレッド全体から作る
+func BuildReplyGraph(list []Res) *ReplyGraph {
+	g := NewReplyGraph()
+	g.Add(list...)
+	return g
+}
+
+// レスを追加する
+// 差分取得で増えたレスだけを渡せばよい
+func (g *ReplyGraph) Add(list ...Res) {
+	for _, r := range list {
+		if r.Number <= 0 || r.Number > REPLY_MAX_RES {
+			continue
+		}
+		g.grow(r.Number)
+		if r.Number > g.last {
+			g.last = r.Number
+		}
+		if len(g.refs[r.Number]) > 0 {
+			// 追加済み
+			continue
+		}
+		seen := make(map[int]bool, 4)
+		for _, tok := range r.Tokens() {
+			if tok.Kind != TOKEN_ANCHOR {
+				continue
+			}
+			for _, ar := range tok.Ranges {
+				to := ar.To
+				if to-ar.From >= REPLY_RANGE_MAX {
+					to = ar.From + REPLY_RANGE_MAX - 1
+				}
+				for n := ar.From; n <= to && n <= REPLY_MAX_RES; n++ {
+					if n == r.Number || seen[n] {
+						continue
+					}
+					seen[n] = true
+					g.grow(n)
+					g.refs[r.Number] = append(g.refs[r.Number], n)
+					g.replies[n] = insertSorted(g.replies[n], r.Number)
+				}
+			}
+		}
+	}
+}
+
+func (g *ReplyGraph) grow(n int) {
+	for len(g.refs) <= n {
+		g.refs = append(g.refs, nil)
+		g.replies = append(g.replies, nil)
+	}
+}
+
+// 番号順に保つ
+func insertSorted(list []int, n int) []int {
+	i := sort.SearchInts(list, n)
+	list = append(list, 0)
+	copy(list[i+1:], list[i:])
+	list[i] = n
+	return list
+}
+
+// 追加済みの最大レス番号
+func (g *ReplyGraph) Len() int {
+	return g.last
+}
+
+// レスnが参照しているレス番号
+func (g *ReplyGraph) References(n int) []int {
+	if n <= 0 || n >= len(g.refs) {
+		return nil
+	}
+	return append([]int(nil), g.refs[n]...)
+}
+
+// レスnを参照しているレス番号
+func (g *ReplyGraph) Replies(n int) []int {
+	if n <= 0 || n >= len(g.replies) {
+		return nil
+	}
+	return append([]int(nil), g.replies[n]...)
+}
+
+// レスnへの返信数
+func (g *ReplyGraph) ReplyCount(n int) int {
+	if n <= 0 || n >= len(g.replies) {
+		return 0
+	}
+	return len(g.replies[n])
+}
+
+// レスnへの返信をツリーにする
+// 循環しないよう後ろのレスからの返信だけを辿る
+// 各レスは一度だけ、最も浅い位置(同じ深さでは番号の小さいレスの下)に置く
+func (g *ReplyGraph) Tree(n int) *ReplyNode {
+	root := &ReplyNode{Number: n}
+	if n <= 0 || n >= len(g.replies) {
+		return root
+	}
+	visited := map[int]bool{n: true}
+	queue := []*ReplyNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, it := range g.replies[node.Number] {
+			if it <= node.Number || visited[it] {
+				continue
+			}
+			visited[it] = true
+			child := &ReplyNode{Number: it}
+			node.Children = append(node.Children, child)
+			queue = append(queue, child)
+		}
+	}
+	return root
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func res(n int, body string) Res {
+	return Res{Number: n, Body: body}
+}
+
+func TestReplyGraph(t *testing.T) {
+	g := BuildReplyGraph([]Res{
+		res(1, "スレ立て"),
+		res(2, "&gt;&gt;1 乙"),
+		res(3, "&gt;&gt;1,2"),
+		res(4, "&gt;&gt;2-3 &gt;&gt;3"),
+		res(5, "&gt;&gt;5 自分"),
+		res(6, "＞＞１"),
+	})
+	if got, want := g.References(4), []int{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("References(4) = %v, want %v", got, want)
+	}
+	if got, want := g.Replies(1), []int{2, 3, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replies(1) = %v, want %v", got, want)
+	}
+	if got := g.References(5); len(got) != 0 {
+		t.Errorf("References(5) = %v, want none", got)
+	}
+	if got := g.ReplyCount(3); got != 1 {
+		t.Errorf("ReplyCount(3) = %d, want 1", got)
+	}
+	if got := g.ReplyCount(100); got != 0 {
+		t.Errorf("ReplyCount(100) = %d, want 0", got)
+	}
+	if got := g.Len(); got != 6 {
+		t.Errorf("Len() = %d, want 6", got)
+	}
+
+	// 差分で追加
+	g.Add(res(7, "&gt;&gt;6"))
+	if got, want := g.Replies(6), []int{7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replies(6) = %v, want %v", got, want)
+	}
+}
+
+// 各レスは一度だけ、最も浅い位置に置かれる
+func TestReplyTree(t *testing.T) {
+	g := BuildReplyGraph([]Res{
+		res(1, ""),
+		res(2, "&gt;&gt;1"),
+		res(3, "&gt;&gt;2"),
+		res(4, "&gt;&gt;1 &gt;&gt;3"),
+		res(5, "&gt;&gt;3-4"),
+	})
+	got := g.Tree(1)
+	want := &ReplyNode{Number: 1, Children: []*ReplyNode{
+		{Number: 2, Children: []*ReplyNode{
+			{Number: 3},
+		}},
+		{Number: 4, Children: []*ReplyNode{
+			{Number: 5},
+		}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tree(1) = %s, want %s", dumpTree(got), dumpTree(want))
+	}
+	if got := g.Tree(0); got.Number != 0 || len(got.Children) != 0 {
+		t.Errorf("Tree(0) = %s", dumpTree(got))
+	}
+}
+
+// 全てのレスが前の全てのレスを参照しても線形で終わる
+func TestReplyTreeDense(t *testing.T) {
+	const n = 1000
+	list := make([]Res, 0, n)
+	list = append(list, res(1, ""))
+	for i := 2; i <= n; i++ {
+		list = append(list, res(i, fmt.Sprintf("&gt;&gt;1-%d", i-1)))
+	}
+	g := BuildReplyGraph(list)
+	start := time.Now()
+	tree := g.Tree(1)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Tree took %v", d)
+	}
+	if got := countNodes(tree); got != n {
+		t.Errorf("Tree(1) has %d nodes, want %d", got, n)
+	}
+	if got := len(tree.Children); got != n-1 {
+		// 全て>>1への返信の下に置く
+		t.Errorf("Tree(1) has %d children, want %d", got, n-1)
+	}
+}
+
+func countNodes(node *ReplyNode) int {
+	c := 1
+	for _, it := range node.Children {
+		c += countNodes(it)
+	}
+	return c
+}
+
+func dumpTree(node *ReplyNode) string {
+	s := fmt.Sprint(node.Number)
+	if len(node.Children) > 0 {
+		s += "["
+		for i, it := range node.Children {
+			if i > 0 {
+				s += " "
+			}
+			s += dumpTree(it)
+		}
+		s += "]"
+	}
+	return s
+}
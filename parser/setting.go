@@ -0,0 +1,151 @@
+package parser
+
+// SETTING.TXTの解析
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// 板の設定
+// 全ての項目をkey=valueの形で保持する
+type BoardSettings struct {
+	m    map[string]string
+	keys []string // ファイル内の順番
+}
+
+func ParseSetting(data []byte, enc Encoding) *BoardSettings {
+	bs := &BoardSettings{
+		m:    make(map[string]string, 64),
+		keys: make([]string, 0, 64),
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 4096), LINE_MAX_SIZE)
+	for sc.Scan() {
+		// 変換できないバイトは置換するため失敗しない
+		line, _ := decodeLine(sc.Bytes(), enc, nil)
+		line = strings.TrimRight(line, "\r\n")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			// 1行目の「板のURL」等は飛ばす
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		if _, ok := bs.m[key]; !ok {
+			bs.keys = append(bs.keys, key)
+		}
+		bs.m[key] = line[i+1:]
+	}
+	return bs
+}
+
+func (bs *BoardSettings) Get(key string) (string, bool) {
+	v, ok := bs.m[key]
+	return v, ok
+}
+
+// 項目名をファイル内の順番で返す
+func (bs *BoardSettings) Keys() []string {
+	return append([]string(nil), bs.keys...)
+}
+
+// 全項目の複製を返す
+func (bs *BoardSettings) Map() map[string]string {
+	m := make(map[string]string, len(bs.m))
+	for k, v := range bs.m {
+		m[k] = v
+	}
+	return m
+}
+
+// 数値の項目
+func (bs *BoardSettings) Int(key string) (int, bool) {
+	v, ok := bs.m[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// チェックボックスの項目
+// 「checked」または数値の1以上で有効
+func (bs *BoardSettings) Bool(key string) bool {
+	v := strings.TrimSpace(bs.m[key])
+	if v == "checked" {
+		return true
+	}
+	n, err := strconv.Atoi(v)
+	return err == nil && n > 0
+}
+
+func (bs *BoardSettings) Title() string {
+	return bs.m["BBS_TITLE"]
+}
+
+// 「＠」以降を除いた板名
+func (bs *BoardSettings) BoardName() string {
+	name := bs.Title()
+	if i := strings.Index(name, "＠"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.Trim(name, " \t")
+}
+
+func (bs *BoardSettings) NonameName() string {
+	return bs.m["BBS_NONAME_NAME"]
+}
+
+// 本文の最大バイト数
+func (bs *BoardSettings) MessageCount() int {
+	n, _ := bs.Int("BBS_MESSAGE_COUNT")
+	return n
+}
+
+// 本文の最大行数
+func (bs *BoardSettings) LineNumber() int {
+	n, _ := bs.Int("BBS_LINE_NUMBER")
+	return n
+}
+
+// スレッドタイトルの最大バイト数
+func (bs *BoardSettings) SubjectCount() int {
+	n, _ := bs.Int("BBS_SUBJECT_COUNT")
+	return n
+}
+
+// 名前欄の最大バイト数
+func (bs *BoardSettings) NameCount() int {
+	n, _ := bs.Int("BBS_NAME_COUNT")
+	return n
+}
+
+// メール欄の最大バイト数
+func (bs *BoardSettings) MailCount() int {
+	n, _ := bs.Int("BBS_MAIL_COUNT")
+	return n
+}
+
+func (bs *BoardSettings) Slip() string {
+	return bs.m["BBS_SLIP"]
+}
+
+// 強制ID表示
+func (bs *BoardSettings) ForceID() bool {
+	return bs.Bool("BBS_FORCE_ID")
+}
+
+// ID非表示
+func (bs *BoardSettings) NoID() bool {
+	return bs.Bool("BBS_NO_ID")
+}
+
+// BEログイン必須
+func (bs *BoardSettings) BeID() bool {
+	return bs.Bool("BBS_BE_ID")
+}
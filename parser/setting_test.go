@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSetting = "http://news.2ch.net/news/\n" +
+	"BBS_TITLE=ニュース速報＠2ch掲示板\n" +
+	"BBS_NONAME_NAME=以下、名無しにかわりましてVIPがお送りします\n" +
+	"BBS_MESSAGE_COUNT=2048\n" +
+	"BBS_LINE_NUMBER= 16 \n" +
+	"BBS_FORCE_ID=checked\n" +
+	"BBS_NO_ID=\n" +
+	"BBS_BE_ID=1\r\n" +
+	"BBS_SLIP=vvvv\n" +
+	"BBS_UNKNOWN=a=b\n" +
+	"BBS_SUBJECT_COUNT=abc\n"
+
+func TestParseSetting(t *testing.T) {
+	bs := ParseSetting([]byte(testSetting), ENC_UTF8)
+	if got := bs.Title(); got != "ニュース速報＠2ch掲示板" {
+		t.Errorf("Title() = %q", got)
+	}
+	if got := bs.BoardName(); got != "ニュース速報" {
+		t.Errorf("BoardName() = %q", got)
+	}
+	if got := bs.NonameName(); got != "以下、名無しにかわりましてVIPがお送りします" {
+		t.Errorf("NonameName() = %q", got)
+	}
+	if got := bs.MessageCount(); got != 2048 {
+		t.Errorf("MessageCount() = %d", got)
+	}
+	if got := bs.LineNumber(); got != 16 {
+		t.Errorf("LineNumber() = %d", got)
+	}
+	if _, ok := bs.Int("BBS_SUBJECT_COUNT"); ok {
+		t.Error("Int() accepted non-numeric value")
+	}
+	if !bs.ForceID() || bs.NoID() || !bs.BeID() {
+		t.Errorf("ForceID() = %v, NoID() = %v, BeID() = %v", bs.ForceID(), bs.NoID(), bs.BeID())
+	}
+	if got := bs.Slip(); got != "vvvv" {
+		t.Errorf("Slip() = %q", got)
+	}
+	// 値に=を含む項目
+	if v, ok := bs.Get("BBS_UNKNOWN"); !ok || v != "a=b" {
+		t.Errorf("Get() = %q, %v", v, ok)
+	}
+	want := []string{"BBS_TITLE", "BBS_NONAME_NAME", "BBS_MESSAGE_COUNT", "BBS_LINE_NUMBER",
+		"BBS_FORCE_ID", "BBS_NO_ID", "BBS_BE_ID", "BBS_SLIP", "BBS_UNKNOWN", "BBS_SUBJECT_COUNT"}
+	if got := bs.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v", got)
+	}
+	m := bs.Map()
+	m["BBS_TITLE"] = "x"
+	if bs.Title() == "x" {
+		t.Error("Map() is not a copy")
+	}
+}
+
+func TestParseSettingSJIS(t *testing.T) {
+	// BBS_TITLE=テスト＠2ch
+	bs := ParseSetting([]byte("BBS_TITLE=\x83\x65\x83\x58\x83\x67\x81\x97\x32ch\n"), ENC_SJIS)
+	if got := bs.BoardName(); got != "テスト" {
+		t.Errorf("BoardName() = %q", got)
+	}
+}
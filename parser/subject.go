@@ -0,0 +1,138 @@
+package parser
+
+// subject.txtの解析
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SUBJECT_DAT_EXT = ".dat"
+	DAY_SEC         = 24 * 60 * 60
+)
+
+// subject.txtの1行分
+type ThreadEntry struct {
+	Key     string    // スレッドキー
+	Title   string    // スレッドタイトル
+	Res     int       // レス数
+	Created time.Time // スレッドキーから求めた作成時間
+	Rank    int       // subject.txtでの順位(1から)
+}
+
+// 1日あたりのレス数
+func (e ThreadEntry) Momentum(now time.Time) float64 {
+	sec := now.Sub(e.Created).Seconds()
+	if sec < 1 {
+		sec = 1
+	}
+	return float64(e.Res) * DAY_SEC / sec
+}
+
+// subject.txtを1行ずつ読む
+type SubjectScanner struct {
+	sc    *bufio.Scanner
+	enc   Encoding
+	dec   Decoder
+	rank  int
+	entry ThreadEntry
+	err   error
+}
+
+func NewSubjectScanner(r io.Reader, enc Encoding) *SubjectScanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), LINE_MAX_SIZE)
+	return &SubjectScanner{
+		sc:  sc,
+		enc: enc,
+	}
+}
+
+// SJIS-winの行の変換方法を変える
+// nilの場合は変換できないバイトを置換する
+func (s *SubjectScanner) SetDecoder(dec Decoder) {
+	s.dec = dec
+}
+
+// 次のスレッドを読む
+// 形式が合わない行は飛ばし、変換に失敗した場合はそこで止まる
+func (s *SubjectScanner) Scan() bool {
+	for s.err == nil && s.sc.Scan() {
+		line, err := decodeLine(s.sc.Bytes(), s.enc, s.dec)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		e, ok := ParseSubjectLine(line, s.rank+1)
+		if ok {
+			s.rank++
+			s.entry = e
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SubjectScanner) Entry() ThreadEntry {
+	return s.entry
+}
+
+func (s *SubjectScanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.sc.Err()
+}
+
+// subject.txt全体を解析する
+func ParseSubject(data []byte, enc Encoding) []ThreadEntry {
+	list, _ := ParseSubjectDecoder(data, enc, nil)
+	return list
+}
+
+// decで変換する
+// 変換に失敗した場合は失敗した行の前までとエラーを返す
+func ParseSubjectDecoder(data []byte, enc Encoding, dec Decoder) ([]ThreadEntry, error) {
+	list := make([]ThreadEntry, 0, 1024)
+	s := NewSubjectScanner(bytes.NewReader(data), enc)
+	s.SetDecoder(dec)
+	for s.Scan() {
+		list = append(list, s.Entry())
+	}
+	return list, s.Err()
+}
+
+// UTF-8の1行を解析する
+// 「1234567890.dat<>タイトル (123)」の形式
+func ParseSubjectLine(line string, rank int) (ThreadEntry, bool) {
+	e := ThreadEntry{Rank: rank}
+	line = strings.TrimRight(line, "\r\n")
+	i := strings.Index(line, DAT_SEPARATOR)
+	if i < 0 {
+		return e, false
+	}
+	e.Key = strings.TrimSuffix(line[:i], SUBJECT_DAT_EXT)
+	key, err := strconv.ParseInt(e.Key, 10, 64)
+	if err != nil {
+		return e, false
+	}
+	e.Created = time.Unix(key, 0)
+
+	title := strings.TrimSpace(line[i+len(DAT_SEPARATOR):])
+	// 末尾の「(レス数)」を取り出す
+	if strings.HasSuffix(title, ")") {
+		if j := strings.LastIndex(title, "("); j >= 0 {
+			if n, err := strconv.Atoi(title[j+1 : len(title)-1]); err == nil {
+				e.Res = n
+				title = strings.TrimSpace(title[:j])
+			}
+		}
+	}
+	e.Title = title
+	return e, true
+}
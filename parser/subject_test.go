@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSubject(t *testing.T) {
+	data := "1400000000.dat<>スレタイ (123)\n" +
+		"壊れた行\n" +
+		"1400000100.dat<>括弧(あり) (1)\r\n" +
+		"1400000200.dat<>レス数なし\n"
+	list := ParseSubject([]byte(data), ENC_UTF8)
+	want := []ThreadEntry{
+		{Key: "1400000000", Title: "スレタイ", Res: 123, Created: time.Unix(1400000000, 0), Rank: 1},
+		{Key: "1400000100", Title: "括弧(あり)", Res: 1, Created: time.Unix(1400000100, 0), Rank: 2},
+		{Key: "1400000200", Title: "レス数なし", Res: 0, Created: time.Unix(1400000200, 0), Rank: 3},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("len = %d, want %d", len(list), len(want))
+	}
+	for i := range want {
+		if list[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, list[i], want[i])
+		}
+	}
+}
+
+func TestParseSubjectLine(t *testing.T) {
+	if _, ok := ParseSubjectLine("abc.dat<>タイトル (1)", 1); ok {
+		t.Error("ParseSubjectLine() accepted non-numeric key")
+	}
+	if _, ok := ParseSubjectLine("1400000000.dat", 1); ok {
+		t.Error("ParseSubjectLine() accepted line without separator")
+	}
+	// SJIS
+	list := ParseSubject([]byte("1400000000.dat<>\x83\x65\x83\x58\x83\x67 (5)\n"), ENC_SJIS)
+	if len(list) != 1 || list[0].Title != "テスト" || list[0].Res != 5 {
+		t.Errorf("ParseSubject(SJIS) = %+v", list)
+	}
+}
+
+func TestMomentum(t *testing.T) {
+	e := ThreadEntry{Res: 100, Created: time.Unix(1400000000, 0)}
+	if got := e.Momentum(time.Unix(1400000000+DAY_SEC/2, 0)); math.Abs(got-200) > 1e-9 {
+		t.Errorf("Momentum() = %f, want 200", got)
+	}
+	// 作成直後や未来の場合も0除算しない
+	if got := e.Momentum(time.Unix(1400000000, 0)); math.IsInf(got, 0) || got != 100*DAY_SEC {
+		t.Errorf("Momentum() at creation = %f", got)
+	}
+}
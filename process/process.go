@@ -41,21 +41,28 @@ type boardNamePacket struct {
 	name  string
 }
 type BoardNameBox struct {
-	m   map[string]string
-	wch chan<- boardNamePacket
-	mux sync.RWMutex
+	m    map[string]string
+	wch  chan<- boardNamePacket
+	done chan struct{}
+	once sync.Once
+	mux  sync.RWMutex
 }
 
 func NewBoardNameBox() *BoardNameBox {
 	ch := make(chan boardNamePacket, 4)
 	bn := &BoardNameBox{
-		m:   make(map[string]string, 1024),
-		wch: ch,
+		m:    make(map[string]string, 1024),
+		wch:  ch,
+		done: make(chan struct{}),
 	}
 	go func(bn *BoardNameBox, rch <-chan boardNamePacket) {
-		c := time.Tick(BOARD_NAME_TIME)
+		t := time.NewTicker(BOARD_NAME_TIME)
+		defer t.Stop()
+		c := t.C
 		for {
 			select {
+			case <-bn.done:
+				return
 			case it := <-rch:
 				bn.mux.Lock()
 				bn.m[it.board] = it.name
@@ -74,7 +81,18 @@ func (bn *BoardNameBox) SetName(board, bname string) {
 		board: board,
 		name:  bname,
 	}
-	bn.wch <- bnp
+	select {
+	case bn.wch <- bnp:
+	case <-bn.done:
+		// 停止後は登録しない
+	}
+}
+
+// 更新処理を止める
+func (bn *BoardNameBox) Close() {
+	bn.once.Do(func() {
+		close(bn.done)
+	})
 }
 func (bn *BoardNameBox) GetName(board string) (name string) {
 	bn.mux.RLock()
@@ -84,23 +102,28 @@ func (bn *BoardNameBox) GetName(board string) (name string) {
 }
 
 type BBNCacheBox struct {
-	cm  map[string]time.Time
-	wch chan<- string
-	dch chan<- string
-	mux sync.RWMutex
+	cm   map[string]time.Time
+	wch  chan<- string
+	dch  chan<- string
+	done chan struct{}
+	once sync.Once
+	mux  sync.RWMutex
 }
 
 func NewBBNCacheBox() *BBNCacheBox {
 	ch := make(chan string, 1)
 	dch := make(chan string, 1)
 	bbn := &BBNCacheBox{
-		cm:  make(map[string]time.Time),
-		wch: ch,
-		dch: dch,
+		cm:   make(map[string]time.Time),
+		wch:  ch,
+		dch:  dch,
+		done: make(chan struct{}),
 	}
 	go func(bbn *BBNCacheBox, rch <-chan string, drch <-chan string) {
 		for {
 			select {
+			case <-bbn.done:
+				return
 			case key := <-rch:
 				// バーボン期間設定
 				bbn.mux.Lock()
@@ -116,7 +139,10 @@ func NewBBNCacheBox() *BBNCacheBox {
 	return bbn
 }
 func (bbn *BBNCacheBox) SetBourbon(key string) {
-	bbn.wch <- key
+	select {
+	case bbn.wch <- key:
+	case <-bbn.done:
+	}
 }
 func (bbn *BBNCacheBox) GetBourbon(key string) bool {
 	bbn.mux.RLock()
@@ -125,9 +151,19 @@ func (bbn *BBNCacheBox) GetBourbon(key string) bool {
 	if ok {
 		if time.Now().After(t) {
 			// 期間経過
-			bbn.dch <- key
+			select {
+			case bbn.dch <- key:
+			case <-bbn.done:
+			}
 			ok = false
 		}
 	}
 	return ok
 }
+
+// 更新処理を止める
+func (bbn *BBNCacheBox) Close() {
+	bbn.once.Do(func() {
+		close(bbn.done)
+	})
+}
@@ -0,0 +1,114 @@
+package get2ch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 接続先毎の取得間隔の制限
+// 0の項目は制限しない
+type RateLimit struct {
+	RequestsPerSecond float64 // 1秒あたりのリクエスト数
+	Burst             int     // 連続して送れるリクエスト数 0の場合は1
+	MaxConns          int     // 同時に接続する数
+}
+
+// 接続先毎の状態
+type hostBucket struct {
+	tokens float64
+	last   time.Time
+	conns  chan struct{}
+}
+
+// Client内で共有する制限
+// 中継サーバを使う場合は中継サーバ毎に分けて数える
+type rateLimiter struct {
+	rl    RateLimit
+	hosts map[string]*hostBucket
+	mux   sync.Mutex
+}
+
+func newRateLimiter(rl RateLimit) *rateLimiter {
+	if rl.RequestsPerSecond <= 0 && rl.MaxConns <= 0 {
+		// 制限しない
+		return nil
+	}
+	if rl.Burst <= 0 {
+		rl.Burst = 1
+	}
+	return &rateLimiter{
+		rl:    rl,
+		hosts: make(map[string]*hostBucket, 64),
+	}
+}
+
+func (l *rateLimiter) bucket(key string) *hostBucket {
+	hb, ok := l.hosts[key]
+	if !ok {
+		hb = &hostBucket{
+			tokens: float64(l.rl.Burst),
+			last:   time.Now(),
+		}
+		if l.rl.MaxConns > 0 {
+			hb.conns = make(chan struct{}, l.rl.MaxConns)
+		}
+		l.hosts[key] = hb
+	}
+	return hb
+}
+
+// 順番が来るまで待つ
+// 終わったら戻り値の関数を呼んで接続数を戻すこと
+func (l *rateLimiter) wait(ctx context.Context, key string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mux.Lock()
+	hb := l.bucket(key)
+	var wait time.Duration
+	if rps := l.rl.RequestsPerSecond; rps > 0 {
+		now := time.Now()
+		hb.tokens += now.Sub(hb.last).Seconds() * rps
+		if hb.tokens > float64(l.rl.Burst) {
+			hb.tokens = float64(l.rl.Burst)
+		}
+		hb.last = now
+		// 先に予約しておき、足りない分だけ待つ
+		hb.tokens--
+		if hb.tokens < 0 {
+			wait = time.Duration(-hb.tokens / rps * float64(time.Second))
+		}
+	}
+	l.mux.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			l.cancel(hb)
+			return nil, ctx.Err()
+		}
+	}
+	if hb.conns == nil {
+		return func() {}, nil
+	}
+	select {
+	case hb.conns <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() { <-hb.conns }, nil
+}
+
+// 予約を取り消す
+func (l *rateLimiter) cancel(hb *hostBucket) {
+	if l.rl.RequestsPerSecond <= 0 {
+		return
+	}
+	l.mux.Lock()
+	hb.tokens++
+	l.mux.Unlock()
+}
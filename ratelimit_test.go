@@ -0,0 +1,69 @@
+package get2ch
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 同じ接続先への取得は間隔と同時接続数を守る
+func TestFetchRateLimit(t *testing.T) {
+	var mux sync.Mutex
+	conns, peak := 0, 0
+	c, ft := newFakeClient(t, nil, Options{RateLimit: RateLimit{RequestsPerSecond: 20, MaxConns: 1}}, func(req *http.Request) (*http.Response, error) {
+		mux.Lock()
+		conns++
+		if conns > peak {
+			peak = conns
+		}
+		mux.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mux.Lock()
+		conns--
+		mux.Unlock()
+		return fakeResponse(req, 200, testDat1), nil
+	})
+	const n = 4
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g2ch, _ := c.NewGet2ch(testBoard, strconv.Itoa(1400000000+i))
+			if _, err := g2ch.Fetch(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 最初の1回以外は1/20秒ずつ待つ
+	if d := time.Since(start); d < (n-1)*50*time.Millisecond-10*time.Millisecond {
+		t.Errorf("elapsed = %v", d)
+	}
+	if peak != 1 {
+		t.Errorf("peak conns = %d, want 1", peak)
+	}
+	if got := len(ft.requests()); got != n {
+		t.Errorf("requests = %d, want %d", got, n)
+	}
+}
+
+// 接続先が違う場合は待たない
+func TestFetchRateLimitHosts(t *testing.T) {
+	c, _ := newFakeClient(t, nil, Options{RateLimit: RateLimit{RequestsPerSecond: 1}}, func(req *http.Request) (*http.Response, error) {
+		return fakeResponse(req, 200, testDat1), nil
+	})
+	start := time.Now()
+	for _, board := range []string{"news", "livejupiter"} {
+		g2ch, _ := c.NewGet2ch(board, testThread)
+		if _, err := g2ch.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("elapsed = %v", d)
+	}
+}
@@ -0,0 +1,89 @@
+package get2ch
+
+import (
+	"bytes"
+	"context"
+	"github.com/tanaton/get2ch-go/parser"
+	"time"
+)
+
+// データの取得元
+type Source int
+
+const (
+	SOURCE_NONE    Source = iota // 取得できなかった
+	SOURCE_NETWORK               // 2ちゃんねるから取得
+	SOURCE_CACHE                 // キャッシュから取得
+	SOURCE_BOURBON               // 2chキャッシュサーバから取得
+)
+
+func (s Source) String() string {
+	switch s {
+	case SOURCE_NETWORK:
+		return "network"
+	case SOURCE_CACHE:
+		return "cache"
+	case SOURCE_BOURBON:
+		return "bourbon"
+	}
+	return "none"
+}
+
+// 1回の取得結果
+// 取得後に変更されることはない
+type FetchResult struct {
+	Data      []byte        // SJIS-winのデータ
+	Code      int           // HTTPステータスコード
+	Source    Source        // データの取得元
+	Append    bool          // 差分を追記した場合true
+	AppendAt  int64         // 追記したデータのDataでの開始位置 Data[AppendAt:]が差分
+	NewRes    []parser.Res  // 追記したレス 差分取得以外ではnil
+	Modified  int64         // 最終更新時間
+	Size      int64         // 転送したバイト数
+	Lines     int           // 行数
+	Abone     bool          // あぼーんを検知した場合true
+	Truncated bool          // サイズ上限で切り詰めた場合true
+	Attempts  int           // 再試行を含めたHTTPの試行回数
+	Duration  time.Duration // 取得にかかった時間
+}
+
+func (g2ch *Get2ch) Fetch() (FetchResult, error) {
+	return g2ch.FetchContext(context.Background())
+}
+
+// GetDataContextと同じ処理を行い、結果をまとめて返す
+// 同じGet2chで同時に呼び出してもよい
+func (g2ch *Get2ch) FetchContext(ctx context.Context) (FetchResult, error) {
+	w := g2ch.worker()
+	res, err := w.fetch(ctx)
+	g2ch.publish(w)
+	return res, err
+}
+
+// 取得した状態から結果を作る
+// 追記したレスをClientの設定で変換できなかった場合はエラーを返す
+func (g2ch *Get2ch) result(data []byte, start time.Time) (FetchResult, error) {
+	var err error
+	res := FetchResult{
+		Data:      data,
+		Code:      g2ch.code,
+		Source:    g2ch.source,
+		Append:    g2ch.appended,
+		AppendAt:  g2ch.append_at,
+		Modified:  g2ch.mod,
+		Size:      g2ch.transfer,
+		Lines:     bytes.Count(data, []byte{'\n'}),
+		Abone:     g2ch.abone,
+		Truncated: g2ch.truncated,
+		Attempts:  g2ch.attempts,
+		Duration:  time.Since(start),
+	}
+	if res.Append && res.AppendAt <= int64(len(data)) {
+		// 差分だけを解析する
+		// 最初のレス番号は全体の行数から差分の行数を引いて求める
+		diff := data[res.AppendAt:]
+		first := res.Lines - bytes.Count(diff, []byte{'\n'}) + 1
+		res.NewRes, err = parser.ParseFromDecoder(diff, parser.ENC_SJIS, g2ch.client.codec, first)
+	}
+	return res, err
+}
@@ -0,0 +1,158 @@
+package get2ch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testDat1 = "a<>sage<>2014/01/01 00:00:00.00<> 1 <>test\n"
+	testDat2 = "b<>sage<>2014/01/01 00:00:01.00<> 2 <>\n"
+)
+
+// 決まった応答を返すRoundTripper
+type fakeTransport struct {
+	fn   func(req *http.Request) (*http.Response, error)
+	reqs []*http.Request
+	mux  sync.Mutex
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mux.Lock()
+	f.reqs = append(f.reqs, req)
+	f.mux.Unlock()
+	return f.fn(req)
+}
+
+func (f *fakeTransport) requests() []*http.Request {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]*http.Request(nil), f.reqs...)
+}
+
+func fakeResponse(req *http.Request, code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    code,
+		Status:        http.StatusText(code),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Last-Modified": {"Wed, 01 Jan 2014 00:00:00 GMT"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// 板一覧を保存済みにして、通信をfnに任せるClient
+func newFakeClient(t *testing.T, cache Cache, opt Options, fn func(req *http.Request) (*http.Response, error)) (*Client, *fakeTransport) {
+	t.Helper()
+	if cache == nil {
+		cache = NewMemoryCache(0, 0)
+	}
+	if err := cache.SetData("", "", "", []byte(legacyMenu)); err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTransport{fn: fn}
+	opt.Cache = cache
+	opt.Transport = ft
+	opt.SkipMenuFetch = true
+	c := NewClient(opt)
+	t.Cleanup(func() { c.Close() })
+	return c, ft
+}
+
+// 同じGet2chで同時に取得しても結果が混ざらない
+func TestFetchConcurrent(t *testing.T) {
+	c, _ := newFakeClient(t, nil, Options{}, func(req *http.Request) (*http.Response, error) {
+		return fakeResponse(req, 200, testDat1), nil
+	})
+	g2ch, err := c.NewGet2ch("news", testThread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := g2ch.Fetch()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(res.Data, []byte(testDat1)) || res.Lines != 1 {
+				t.Errorf("Fetch() = %q, %d lines", res.Data, res.Lines)
+			}
+			// 公開された状態も読める
+			g2ch.GetHttpCode()
+			g2ch.GetByteSize()
+		}()
+	}
+	wg.Wait()
+	if code := g2ch.GetHttpCode(); code != 200 && code != 304 && code != 206 {
+		t.Errorf("GetHttpCode() = %d", code)
+	}
+}
+
+// 差分取得では追記した位置と追記したレスだけを返す
+func TestFetchAppend(t *testing.T) {
+	c, ft := newFakeClient(t, nil, Options{}, func(req *http.Request) (*http.Response, error) {
+		switch req.Header.Get("Range") {
+		case "":
+			return fakeResponse(req, 200, testDat1), nil
+		case "bytes=" + strconv.Itoa(len(testDat1)-1) + "-":
+			return fakeResponse(req, 206, "\n"+testDat2), nil
+		}
+		return fakeResponse(req, 416, ""), nil
+	})
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	res, err := g2ch.Fetch()
+	if err != nil || res.Append || res.NewRes != nil {
+		t.Fatalf("Fetch() = %+v, %v", res, err)
+	}
+	res, err = g2ch.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != 206 || !res.Append || res.AppendAt != int64(len(testDat1)) || res.Lines != 2 {
+		t.Errorf("Fetch() = %d %v %d %d", res.Code, res.Append, res.AppendAt, res.Lines)
+	}
+	if string(res.Data[res.AppendAt:]) != testDat2 {
+		t.Errorf("Data[AppendAt:] = %q", res.Data[res.AppendAt:])
+	}
+	if len(res.NewRes) != 1 || res.NewRes[0].Number != 2 || res.NewRes[0].Name != "b" {
+		t.Errorf("NewRes = %+v", res.NewRes)
+	}
+	if n := len(ft.requests()); n != 2 {
+		t.Errorf("requests = %d", n)
+	}
+}
+
+// 追記したレスはClientの設定で変換する
+func TestFetchAppendCodec(t *testing.T) {
+	const bad = "b<><><>\x81 <>\n"
+	c, _ := newFakeClient(t, nil, Options{Codec: CODEC_ERROR}, func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Range") == "" {
+			return fakeResponse(req, 200, testDat1), nil
+		}
+		return fakeResponse(req, 206, "\n"+bad), nil
+	})
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	if _, err := g2ch.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := g2ch.Fetch()
+	if !errors.Is(err, ErrCodec) {
+		t.Errorf("Fetch() err = %v, want ErrCodec", err)
+	}
+	if string(res.Data) != testDat1+bad || len(res.NewRes) != 0 {
+		t.Errorf("Fetch() = %q, %+v", res.Data, res.NewRes)
+	}
+}
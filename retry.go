@@ -0,0 +1,179 @@
+package get2ch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	RETRY_MAX_ATTEMPTS = 3                      // 初回を含めた試行回数
+	RETRY_BASE_WAIT    = 500 * time.Millisecond // 初回の待ち時間
+	RETRY_MAX_WAIT     = 10 * time.Second       // 待ち時間の上限
+	RETRY_JITTER       = 0.5                    // 待ち時間をずらす割合
+)
+
+// 再試行の方針
+// attemptは失敗した回数(1から)、codeは通信エラーの場合0
+// 再試行する場合は待ち時間とtrueを返す
+type RetryPolicy interface {
+	Retry(attempt int, code int, err error) (time.Duration, bool)
+}
+
+// 指数的に待ち時間を延ばす再試行
+type Backoff struct {
+	MaxAttempts int                  // 初回を含めた最大試行回数
+	Base        time.Duration        // 初回の待ち時間
+	Max         time.Duration        // 待ち時間の上限
+	Jitter      float64              // 待ち時間をずらす割合 0〜1
+	Codes       map[int]bool         // 再試行するHTTPステータスコード nilの場合は標準の設定
+	Errors      func(err error) bool // 再試行する通信エラーの判定 nilの場合は標準の判定
+}
+
+var retryCodes = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// 標準の設定で生成する
+func NewBackoff() *Backoff {
+	return &Backoff{
+		MaxAttempts: RETRY_MAX_ATTEMPTS,
+		Base:        RETRY_BASE_WAIT,
+		Max:         RETRY_MAX_WAIT,
+		Jitter:      RETRY_JITTER,
+	}
+}
+
+func (b *Backoff) Retry(attempt int, code int, err error) (time.Duration, bool) {
+	if attempt >= b.MaxAttempts {
+		return 0, false
+	}
+	if err != nil {
+		f := b.Errors
+		if f == nil {
+			f = retryableError
+		}
+		if !f(err) {
+			return 0, false
+		}
+	} else {
+		codes := b.Codes
+		if codes == nil {
+			codes = retryCodes
+		}
+		if !codes[code] {
+			return 0, false
+		}
+	}
+	wait := b.Base
+	for i := 1; i < attempt && (b.Max <= 0 || wait < b.Max); i++ {
+		wait *= 2
+	}
+	if b.Max > 0 && wait > b.Max {
+		wait = b.Max
+	}
+	if b.Jitter > 0 {
+		wait -= time.Duration(float64(wait) * b.Jitter * rand.Float64())
+	}
+	return wait, true
+}
+
+// 再試行する接続のエラー
+// 接続の切断・拒否と、応答の途中で切れた場合
+var connErrors = []error{
+	syscall.ECONNRESET,
+	syscall.ECONNREFUSED,
+	syscall.ECONNABORTED,
+	io.ErrUnexpectedEOF,
+	io.EOF,
+}
+
+// 一時的な通信エラーか判定する
+// url.Errorは全てnet.Errorを満たすため、中身を見て判定する
+// (不正なURLやスキーム等は再試行しない)
+func retryableError(err error) bool {
+	var terr *TimeoutError
+	if errors.As(err, &terr) {
+		return true
+	}
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		if uerr.Timeout() {
+			return true
+		}
+		err = uerr.Err
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	for _, e := range connErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// 再試行を含めたやり取りの結果
+type exchange struct {
+	resp     *http.Response // Bodyは閉じられている
+	data     []byte
+	n        int64 // 転送量の合計
+	attempts int   // 試行回数
+	err      error
+}
+
+// 方針に従って再試行する
+// 差分取得の判定に関わるため、応答が返ってきた場合は5xx等の失敗以外再試行しない
+// keyは取得間隔を制限する単位(中継サーバ+接続先)
+func (c *Client) do(ctx context.Context, key string, req *http.Request, t Timeouts, limit int64) (ex exchange) {
+	for {
+		release, err := c.limiter.wait(ctx, key)
+		if err != nil {
+			ex.resp, ex.data, ex.err = nil, nil, err
+			return
+		}
+		var n int64
+		ex.attempts++
+		ex.resp, ex.data, n, ex.err = c.roundTrip(ctx, req, t, limit)
+		ex.n += n
+		release()
+		if c.retry == nil || ctx.Err() != nil {
+			return
+		}
+		code := 0
+		if ex.err != nil {
+			if ex.resp != nil || errors.Is(ex.err, ErrTruncated) {
+				// リダイレクトや切り詰めは再試行しても変わらない
+				return
+			}
+		} else {
+			code = ex.resp.StatusCode
+			if code < 500 && code != http.StatusTooManyRequests {
+				// 206や416等は呼び出し側で判定する
+				return
+			}
+		}
+		wait, ok := c.retry.Retry(ex.attempts, code, ex.err)
+		if !ok {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package get2ch
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryableError(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com/", Err: err}
+	}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", &TimeoutError{Kind: TIMEOUT_CONNECT}, true},
+		{"reset", wrap(reset), true},
+		{"refused", wrap(refused), true},
+		{"unexpected eof", wrap(io.ErrUnexpectedEOF), true},
+		{"scheme", wrap(errors.New("unsupported protocol scheme \"\"")), false},
+		{"dns", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}), false},
+		{"other", errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := retryableError(tt.err); got != tt.want {
+			t.Errorf("%s: retryableError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// 失敗した応答と一時的な通信エラーだけを再試行し、試行回数を返す
+func TestFetchRetry(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	dns := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: testServer}}
+	status := func(code int, body string) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return fakeResponse(req, code, body), nil
+		}
+	}
+	fail := func(err error) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return nil, err
+		}
+	}
+	policy := &Backoff{MaxAttempts: 3, Base: time.Millisecond}
+	tests := []struct {
+		name     string
+		retry    RetryPolicy
+		steps    []func(req *http.Request) (*http.Response, error) // 最後の応答を繰り返す
+		code     int
+		attempts int
+	}{
+		{"503", policy, []func(req *http.Request) (*http.Response, error){status(503, ""), status(200, testDat1)}, 200, 2},
+		{"reset", policy, []func(req *http.Request) (*http.Response, error){fail(reset), status(200, testDat1)}, 200, 2},
+		{"give up", policy, []func(req *http.Request) (*http.Response, error){status(503, "")}, 503, 3},
+		{"404", policy, []func(req *http.Request) (*http.Response, error){status(404, ""), status(200, testDat1)}, 404, 1},
+		{"dns", policy, []func(req *http.Request) (*http.Response, error){fail(dns), status(200, testDat1)}, 0, 1},
+		{"no policy", nil, []func(req *http.Request) (*http.Response, error){status(503, ""), status(200, testDat1)}, 503, 1},
+	}
+	for _, tt := range tests {
+		var mux sync.Mutex
+		n := 0
+		c, ft := newFakeClient(t, nil, Options{Retry: tt.retry}, func(req *http.Request) (*http.Response, error) {
+			mux.Lock()
+			i := n
+			n++
+			mux.Unlock()
+			if i >= len(tt.steps) {
+				i = len(tt.steps) - 1
+			}
+			return tt.steps[i](req)
+		})
+		g2ch, _ := c.NewGet2ch(testBoard, testThread)
+		res, _ := g2ch.Fetch()
+		if res.Code != tt.code || res.Attempts != tt.attempts {
+			t.Errorf("%s: Fetch() = code %d attempts %d, want %d %d", tt.name, res.Code, res.Attempts, tt.code, tt.attempts)
+		}
+		if got := len(ft.requests()); got != tt.attempts {
+			t.Errorf("%s: requests = %d, want %d", tt.name, got, tt.attempts)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package get2ch
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// Windowsではsyscall.ECONNRESET等と別の値になる
+func init() {
+	connErrors = append(connErrors,
+		windows.WSAECONNRESET,
+		windows.WSAECONNREFUSED,
+		windows.WSAECONNABORTED,
+	)
+}
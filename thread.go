@@ -0,0 +1,130 @@
+package get2ch
+
+import (
+	"bytes"
+	"context"
+	"github.com/tanaton/get2ch-go/parser"
+	"strconv"
+	"time"
+)
+
+const (
+	THREAD_RES_MAX  = 1000       // これ以上書き込めないレス数
+	THREAD_SIZE_MAX = 512 * 1024 // これ以上書き込めないdatのサイズ
+)
+
+// スレッドの状態
+type ThreadState int
+
+const (
+	THREAD_UNKNOWN ThreadState = iota // 判断できない
+	THREAD_ACTIVE                     // subject.txtに載っている
+	THREAD_FULL                       // 1000レスまたは容量制限に達した
+	THREAD_DROPPED                    // dat落ち
+	THREAD_BOURBON                    // バーボン中のため2chキャッシュサーバから取得している
+)
+
+func (s ThreadState) String() string {
+	switch s {
+	case THREAD_ACTIVE:
+		return "active"
+	case THREAD_FULL:
+		return "full"
+	case THREAD_DROPPED:
+		return "dropped"
+	case THREAD_BOURBON:
+		return "bourbon"
+	}
+	return "unknown"
+}
+
+// スレッドの情報
+type ThreadInfo struct {
+	Key      string              // スレッドキー
+	Title    string              // スレッドタイトル
+	Created  time.Time           // スレッドキーから求めた作成時間
+	Res      int                 // レス数
+	Size     int64               // datのサイズ
+	Modified int64               // datの最終更新時間
+	Cached   bool                // datがキャッシュにある場合true
+	Entry    *parser.ThreadEntry // subject.txtでの情報 載っていない場合はnil
+	State    ThreadState
+}
+
+// スレッドの情報を取得する
+// datはキャッシュのみを参照し、subject.txtは取得する
+func (g2ch *Get2ch) GetThreadInfo() (*ThreadInfo, error) {
+	return g2ch.GetThreadInfoContext(context.Background())
+}
+
+func (g2ch *Get2ch) GetThreadInfoContext(ctx context.Context) (*ThreadInfo, error) {
+	if !g2ch.isThread() {
+		return nil, ErrNotThread
+	}
+	info := &ThreadInfo{Key: g2ch.thread}
+	if key, err := strconv.ParseInt(g2ch.thread, 10, 64); err == nil {
+		info.Created = time.Unix(key, 0)
+	}
+	// キャッシュのdat
+	if st, err := g2ch.cache.Stat(g2ch.server, g2ch.board, g2ch.thread); err == nil {
+		if data, err := g2ch.cache.GetData(g2ch.server, g2ch.board, g2ch.thread); err == nil {
+			info.Cached = true
+			info.Size = st.Size()
+			info.Modified = st.Mmod()
+			info.Res = bytes.Count(data, []byte{'\n'})
+			// タイトルは1レス目だけ解析する
+			sc := parser.NewScanner(bytes.NewReader(data), parser.ENC_SJIS)
+			sc.SetDecoder(g2ch.client.codec)
+			if sc.Scan() {
+				info.Title = sc.Res().Title
+			}
+		}
+	}
+	// 最新のsubject.txt
+	list, lerr := g2ch.subjectList(ctx)
+	if lerr == nil {
+		for i := range list {
+			if list[i].Key == g2ch.thread {
+				info.Entry = &list[i]
+				break
+			}
+		}
+	}
+	if info.Entry != nil {
+		if info.Title == "" {
+			info.Title = info.Entry.Title
+		}
+		if info.Entry.Res > info.Res {
+			// キャッシュより新しい
+			info.Res = info.Entry.Res
+		}
+	}
+	if !info.Cached && info.Entry == nil && lerr != nil {
+		return nil, lerr
+	}
+	info.State = g2ch.threadState(info, lerr == nil)
+	return info, nil
+}
+
+func (g2ch *Get2ch) subjectList(ctx context.Context) ([]parser.ThreadEntry, error) {
+	bg, err := g2ch.client.NewGet2ch(g2ch.board, "")
+	if err != nil {
+		return nil, err
+	}
+	return bg.GetThreadListContext(ctx)
+}
+
+// listedはsubject.txtを取得できた場合true
+func (g2ch *Get2ch) threadState(info *ThreadInfo, listed bool) ThreadState {
+	switch {
+	case info.Res >= THREAD_RES_MAX || info.Size >= THREAD_SIZE_MAX:
+		return THREAD_FULL
+	case g2ch.getBourbonCache():
+		return THREAD_BOURBON
+	case info.Entry != nil:
+		return THREAD_ACTIVE
+	case listed:
+		return THREAD_DROPPED
+	}
+	return THREAD_UNKNOWN
+}
@@ -0,0 +1,141 @@
+package get2ch
+
+import (
+	"context"
+	"sync"
+)
+
+// 2段のキャッシュ
+// upperは高速なキャッシュ(MemoryCache等)、lowerは保存先(FileCache等)
+// 書き込みは両方に行い、lowerから読んだデータはupperに載せる
+type TieredCache struct {
+	upper Cache
+	lower Cache
+	mux   sync.RWMutex // 書き込み中に古いデータをupperへ載せないため
+}
+
+func NewTieredCache(upper, lower Cache) *TieredCache {
+	return &TieredCache{
+		upper: upper,
+		lower: lower,
+	}
+}
+
+func (tc *TieredCache) Path(s, b, t string) string {
+	return tc.lower.Path(s, b, t)
+}
+
+func (tc *TieredCache) GetData(s, b, t string) ([]byte, error) {
+	tc.mux.RLock()
+	defer tc.mux.RUnlock()
+	if d, err := tc.upper.GetData(s, b, t); err == nil {
+		return d, nil
+	}
+	st, err := tc.lower.Stat(s, b, t)
+	if err != nil {
+		return nil, err
+	}
+	d, err := tc.lower.GetData(s, b, t)
+	if err != nil {
+		return nil, err
+	}
+	// 更新時間も合わせて載せる
+	if tc.upper.SetData(s, b, t, d) == nil {
+		tc.upper.SetMod(s, b, t, st.Mmod(), st.Amod())
+	}
+	return d, nil
+}
+
+func (tc *TieredCache) SetData(s, b, t string, d []byte) error {
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	if err := tc.lower.SetData(s, b, t, d); err != nil {
+		return err
+	}
+	tc.upper.SetData(s, b, t, d)
+	return nil
+}
+
+func (tc *TieredCache) SetDataAppend(s, b, t string, d []byte) error {
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	if err := tc.lower.SetDataAppend(s, b, t, d); err != nil {
+		return err
+	}
+	if !tc.upper.Exists(s, b, t) {
+		// 次に読んだ時に載せる
+		return nil
+	}
+	ust, uerr := tc.upper.Stat(s, b, t)
+	lst, lerr := tc.lower.Stat(s, b, t)
+	if uerr == nil && lerr == nil && ust.Size()+int64(len(d)) == lst.Size() {
+		if tc.upper.SetDataAppend(s, b, t, d) == nil {
+			return nil
+		}
+	}
+	// サイズが合わない場合はlowerから載せ直す
+	if lerr == nil {
+		if ld, err := tc.lower.GetData(s, b, t); err == nil {
+			tc.upper.SetData(s, b, t, ld)
+			tc.upper.SetMod(s, b, t, lst.Mmod(), lst.Amod())
+		}
+	}
+	return nil
+}
+
+func (tc *TieredCache) SetMod(s, b, t string, m, a int64) error {
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	if err := tc.lower.SetMod(s, b, t, m, a); err != nil {
+		return err
+	}
+	if tc.upper.Exists(s, b, t) {
+		tc.upper.SetMod(s, b, t, m, a)
+	}
+	return nil
+}
+
+func (tc *TieredCache) Exists(s, b, t string) bool {
+	tc.mux.RLock()
+	defer tc.mux.RUnlock()
+	return tc.upper.Exists(s, b, t) || tc.lower.Exists(s, b, t)
+}
+
+// lowerを修復した場合はupperも載せ直す
+func (tc *TieredCache) Repair(s, b, t string) (bool, error) {
+	rc, ok := tc.lower.(CacheRepairer)
+	if !ok {
+		return false, nil
+	}
+	tc.mux.Lock()
+	defer tc.mux.Unlock()
+	repaired, err := rc.Repair(s, b, t)
+	if !repaired || !tc.upper.Exists(s, b, t) {
+		return repaired, err
+	}
+	if st, err := tc.lower.Stat(s, b, t); err == nil {
+		if d, err := tc.lower.GetData(s, b, t); err == nil {
+			tc.upper.SetData(s, b, t, d)
+			tc.upper.SetMod(s, b, t, st.Mmod(), st.Amod())
+		}
+	}
+	return true, nil
+}
+
+// lowerが排他できる場合はlowerで排他する
+func (tc *TieredCache) Lock(ctx context.Context, s, b, t string) (func(), error) {
+	if cl, ok := tc.lower.(CacheLocker); ok {
+		return cl.Lock(ctx, s, b, t)
+	}
+	return func() {}, nil
+}
+
+// GetDataで返すデータと同じ側の状態を返す
+func (tc *TieredCache) Stat(s, b, t string) (CacheState, error) {
+	tc.mux.RLock()
+	defer tc.mux.RUnlock()
+	if st, err := tc.upper.Stat(s, b, t); err == nil {
+		return st, nil
+	}
+	return tc.lower.Stat(s, b, t)
+}
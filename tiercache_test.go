@@ -0,0 +1,105 @@
+package get2ch
+
+import (
+	"testing"
+)
+
+func TestTieredCache(t *testing.T) {
+	upper := NewMemoryCache(0, 0)
+	lower := NewFileCache(t.TempDir())
+	tc := NewTieredCache(upper, lower)
+	if got, want := tc.Path(testServer, testBoard, testThread), lower.Path(testServer, testBoard, testThread); got != want {
+		t.Errorf("Path() = %q, want %q", got, want)
+	}
+
+	// 書き込みは両方に行う
+	if err := tc.SetData(testServer, testBoard, testThread, []byte("1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetDataAppend(testServer, testBoard, testThread, []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]Cache{"upper": upper, "lower": lower} {
+		if d, err := c.GetData(testServer, testBoard, testThread); err != nil || string(d) != "1\n2\n" {
+			t.Errorf("%s GetData() = %q, %v", name, d, err)
+		}
+	}
+	if err := tc.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := upper.Stat(testServer, testBoard, testThread); err != nil || st.Mmod() != 1400000000 {
+		t.Errorf("upper Stat() = %v, %v", st, err)
+	}
+}
+
+// lowerから読んだデータは更新時間と合わせてupperに載せる
+func TestTieredCachePromote(t *testing.T) {
+	upper := NewMemoryCache(0, 0)
+	lower := NewFileCache(t.TempDir())
+	lower.SetData(testServer, testBoard, testThread, []byte("1\n"))
+	lower.SetMod(testServer, testBoard, testThread, 1400000000, 1400000000)
+	tc := NewTieredCache(upper, lower)
+	if !tc.Exists(testServer, testBoard, testThread) {
+		t.Fatal("Exists() = false")
+	}
+	if d, err := tc.GetData(testServer, testBoard, testThread); err != nil || string(d) != "1\n" {
+		t.Fatalf("GetData() = %q, %v", d, err)
+	}
+	st, err := upper.Stat(testServer, testBoard, testThread)
+	if err != nil || st.Size() != 2 || st.Mmod() != 1400000000 {
+		t.Errorf("upper Stat() = %v, %v", st, err)
+	}
+}
+
+// upperから捨てられた後の追記は、次に読んだ時にlowerから載せる
+// upperとlowerのサイズが合わない場合はlowerに合わせる
+func TestTieredCacheAppend(t *testing.T) {
+	upper := NewMemoryCache(0, 0)
+	lower := NewFileCache(t.TempDir())
+	tc := NewTieredCache(upper, lower)
+	tc.SetData(testServer, testBoard, testThread, []byte("1\n"))
+
+	// 別の経路でlowerだけ書き換わった場合
+	lower.SetDataAppend(testServer, testBoard, testThread, []byte("2\n"))
+	if err := tc.SetDataAppend(testServer, testBoard, testThread, []byte("3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := upper.GetData(testServer, testBoard, testThread); string(d) != "1\n2\n3\n" {
+		t.Errorf("upper GetData() = %q", d)
+	}
+
+	// upperに無い場合は載せない
+	const other = "1400000000"
+	tc.SetData(testServer, testBoard, other, []byte("1\n"))
+	upper2 := NewMemoryCache(0, 0)
+	tc2 := NewTieredCache(upper2, lower)
+	if err := tc2.SetDataAppend(testServer, testBoard, other, []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if upper2.Exists(testServer, testBoard, other) {
+		t.Error("SetDataAppend() loaded missing entry into upper")
+	}
+	if d, _ := tc2.GetData(testServer, testBoard, other); string(d) != "1\n2\n" {
+		t.Errorf("GetData() = %q", d)
+	}
+}
+
+// lowerを修復した場合はupperも合わせる
+func TestTieredCacheRepair(t *testing.T) {
+	upper := NewMemoryCache(0, 0)
+	lower := NewFileCache(t.TempDir())
+	tc := NewTieredCache(upper, lower)
+	tc.SetData(testServer, testBoard, testThread, []byte("1\n2"))
+	repaired, err := tc.Repair(testServer, testBoard, testThread)
+	if err != nil || !repaired {
+		t.Fatalf("Repair() = %v, %v", repaired, err)
+	}
+	if d, _ := upper.GetData(testServer, testBoard, testThread); string(d) != "1\n" {
+		t.Errorf("upper GetData() = %q", d)
+	}
+	// lowerが修復できない場合
+	tc2 := NewTieredCache(NewMemoryCache(0, 0), NewMemoryCache(0, 0))
+	if repaired, err := tc2.Repair(testServer, testBoard, testThread); repaired || err != nil {
+		t.Errorf("Repair() without CacheRepairer = %v, %v", repaired, err)
+	}
+}
@@ -0,0 +1,224 @@
+package get2ch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	CONNECT_TIMEOUT   = 5 * time.Second // 接続確立までの時間
+	HEADER_TIMEOUT    = TIMEOUT_SEC     // リクエスト送信からヘッダ受信までの時間
+	IDLE_READ_TIMEOUT = TIMEOUT_SEC     // ボディ受信中に無通信が続いてよい時間
+)
+
+// タイムアウトの種類
+type TimeoutKind int
+
+const (
+	TIMEOUT_CONNECT TimeoutKind = iota + 1
+	TIMEOUT_HEADER
+	TIMEOUT_IDLE_READ
+	TIMEOUT_TOTAL
+)
+
+func (k TimeoutKind) String() string {
+	switch k {
+	case TIMEOUT_CONNECT:
+		return "connect"
+	case TIMEOUT_HEADER:
+		return "response header"
+	case TIMEOUT_IDLE_READ:
+		return "idle read"
+	case TIMEOUT_TOTAL:
+		return "total"
+	}
+	return "unknown"
+}
+
+// タイムアウト設定
+// 0の項目は標準の設定、負の項目は制限しない
+type Timeouts struct {
+	Connect        time.Duration // 接続確立まで
+	ResponseHeader time.Duration // リクエスト送信からヘッダ受信まで
+	IdleRead       time.Duration // ボディ受信中の無通信
+	Total          time.Duration // 1回のやり取り全体
+}
+
+func defaultTimeouts() Timeouts {
+	return Timeouts{
+		Connect:        CONNECT_TIMEOUT,
+		ResponseHeader: HEADER_TIMEOUT,
+		IdleRead:       IDLE_READ_TIMEOUT,
+	}
+}
+
+// 0以外の値で上書きする
+// 負の値も上書きして制限しない設定にする
+func (t Timeouts) merge(o Timeouts) Timeouts {
+	if o.Connect != 0 {
+		t.Connect = o.Connect
+	}
+	if o.ResponseHeader != 0 {
+		t.ResponseHeader = o.ResponseHeader
+	}
+	if o.IdleRead != 0 {
+		t.IdleRead = o.IdleRead
+	}
+	if o.Total != 0 {
+		t.Total = o.Total
+	}
+	return t
+}
+
+// タイムアウトした場合のエラー
+type TimeoutError struct {
+	Kind  TimeoutKind
+	Limit time.Duration // 設定されていた時間
+	URL   string
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Kind.String() + " timeout (" + e.Limit.String() + ") " + e.URL
+}
+
+// net.Errorと同じ判定ができるようにする
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// 1回のやり取りを見張る
+// 段階毎にタイマーを切り替えて、時間切れになったらctxを中断する
+type watchdog struct {
+	t      Timeouts
+	url    string
+	cancel context.CancelCauseFunc
+	total  *time.Timer
+	phase  *time.Timer
+	mux    sync.Mutex
+}
+
+func startWatchdog(ctx context.Context, req *http.Request, t Timeouts) (context.Context, *watchdog) {
+	w := &watchdog{
+		t:   t,
+		url: req.URL.String(),
+	}
+	ctx, w.cancel = context.WithCancelCause(ctx)
+	if t.Total > 0 {
+		w.total = time.AfterFunc(t.Total, w.fire(TIMEOUT_TOTAL, t.Total))
+	}
+	w.setPhase(TIMEOUT_CONNECT, t.Connect)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			w.setPhase(0, 0)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			w.setPhase(TIMEOUT_HEADER, t.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			w.setPhase(TIMEOUT_IDLE_READ, t.IdleRead)
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), w
+}
+
+func (w *watchdog) fire(kind TimeoutKind, d time.Duration) func() {
+	return func() {
+		w.cancel(&TimeoutError{Kind: kind, Limit: d, URL: w.url})
+	}
+}
+
+func (w *watchdog) setPhase(kind TimeoutKind, d time.Duration) {
+	w.mux.Lock()
+	if w.phase != nil {
+		w.phase.Stop()
+		w.phase = nil
+	}
+	if d > 0 {
+		w.phase = time.AfterFunc(d, w.fire(kind, d))
+	}
+	w.mux.Unlock()
+}
+
+// ボディを読むたびに無通信タイマーを延長する
+func (w *watchdog) body(rc io.ReadCloser) io.ReadCloser {
+	return &idleReader{rc: rc, w: w}
+}
+
+// 時間切れで中断していた場合はTimeoutErrorを返す
+func (w *watchdog) err(ctx context.Context) error {
+	if terr, ok := context.Cause(ctx).(*TimeoutError); ok {
+		return terr
+	}
+	return nil
+}
+
+func (w *watchdog) stop() {
+	w.setPhase(0, 0)
+	if w.total != nil {
+		w.total.Stop()
+	}
+	w.cancel(nil)
+}
+
+type idleReader struct {
+	rc io.ReadCloser
+	w  *watchdog
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.rc.Read(p)
+	if n > 0 {
+		ir.w.setPhase(TIMEOUT_IDLE_READ, ir.w.t.IdleRead)
+	}
+	return n, err
+}
+
+func (ir *idleReader) Close() error {
+	return ir.rc.Close()
+}
+
+// リクエストを送信してボディを全て読み込む
+// 戻り値のrespのBodyは閉じられている
+// リダイレクトを止めた場合はrespとerrの両方を返す
+// limitを超えた場合は切り詰めたデータとrespに*TruncatedErrorを付けて返す
+func (c *Client) roundTrip(ctx context.Context, req *http.Request, t Timeouts, limit int64) (resp *http.Response, data []byte, n int64, err error) {
+	wctx, w := startWatchdog(ctx, req, t)
+	defer w.stop()
+
+	resp, err = c.httpClient().Do(req.WithContext(wctx))
+	if err != nil {
+		// errがnil以外の場合、resp.Bodyは閉じられている
+		if terr := w.err(wctx); terr != nil && ctx.Err() == nil {
+			err = terr
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	body := &countReader{rc: w.body(resp.Body)}
+	resp.Body = body
+	var truncated bool
+	data, truncated, err = responseRead(resp, limit)
+	n = body.n
+	if err != nil {
+		if terr := w.err(wctx); terr != nil && ctx.Err() == nil {
+			err = terr
+		}
+		return nil, nil, n, err
+	}
+	if truncated {
+		err = &TruncatedError{Limit: limit, URL: req.URL.String()}
+	}
+	return
+}
+
+// 個別にタイムアウトを設定する
+// 0の項目はClientの設定を使い、負の項目は制限しない
+func (g2ch *Get2ch) SetTimeouts(t Timeouts) {
+	g2ch.mux.Lock()
+	g2ch.timeouts = g2ch.timeouts.merge(t)
+	g2ch.mux.Unlock()
+}
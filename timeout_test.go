@@ -0,0 +1,121 @@
+package get2ch
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// ctxが終了するまで読めないボディ
+type blockingBody struct {
+	req *http.Request
+}
+
+func (bb blockingBody) Read(p []byte) (int, error) {
+	<-bb.req.Context().Done()
+	return 0, bb.req.Context().Err()
+}
+
+func (bb blockingBody) Close() error { return nil }
+
+// 接続とリクエスト送信が済んだことを知らせる
+func traceSent(req *http.Request) {
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil {
+		trace.GotConn(httptrace.GotConnInfo{})
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+}
+
+// 段階毎のタイムアウトはキャッシュのデータと一緒に*TimeoutErrorとして返す
+func TestFetchTimeout(t *testing.T) {
+	const d = 20 * time.Millisecond
+	block := func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	tests := []struct {
+		name string
+		t    Timeouts
+		fn   func(req *http.Request) (*http.Response, error)
+		want TimeoutKind
+	}{
+		{"connect", Timeouts{Connect: d}, block, TIMEOUT_CONNECT},
+		{"header", Timeouts{ResponseHeader: d}, func(req *http.Request) (*http.Response, error) {
+			traceSent(req)
+			return block(req)
+		}, TIMEOUT_HEADER},
+		{"idle read", Timeouts{IdleRead: d}, func(req *http.Request) (*http.Response, error) {
+			traceSent(req)
+			if trace := httptrace.ContextClientTrace(req.Context()); trace != nil {
+				trace.GotFirstResponseByte()
+			}
+			resp := fakeResponse(req, 200, "")
+			resp.Body = blockingBody{req: req}
+			resp.ContentLength = -1
+			return resp, nil
+		}, TIMEOUT_IDLE_READ},
+		{"total", Timeouts{Connect: -1, ResponseHeader: -1, IdleRead: -1, Total: d}, block, TIMEOUT_TOTAL},
+	}
+	for _, tt := range tests {
+		cache := NewMemoryCache(0, 0)
+		cache.SetData(testServer, testBoard, testThread, []byte(testDat1))
+		c, _ := newFakeClient(t, cache, Options{Timeouts: tt.t}, tt.fn)
+		g2ch, _ := c.NewGet2ch(testBoard, testThread)
+		res, err := g2ch.Fetch()
+		var terr *TimeoutError
+		if !errors.As(err, &terr) || terr.Kind != tt.want {
+			t.Errorf("%s: Fetch() err = %v, want %v timeout", tt.name, err, tt.want)
+		}
+		if res.Source != SOURCE_CACHE || string(res.Data) != testDat1 {
+			t.Errorf("%s: Fetch() = %v %q", tt.name, res.Source, res.Data)
+		}
+	}
+}
+
+// 負の値で標準のタイムアウトを外せる
+func TestFetchNoTimeout(t *testing.T) {
+	c, _ := newFakeClient(t, nil, Options{Timeouts: Timeouts{Connect: 10 * time.Millisecond}}, func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return fakeResponse(req, 200, testDat1), nil
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	})
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	g2ch.SetTimeouts(Timeouts{Connect: -1})
+	if res, err := g2ch.Fetch(); err != nil || res.Code != 200 {
+		t.Errorf("Fetch() = %d, %v", res.Code, err)
+	}
+}
+
+// タイムアウト以外の通信エラーもキャッシュのデータと一緒に返す
+func TestFetchNetworkError(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	cache := NewMemoryCache(0, 0)
+	cache.SetData(testServer, testBoard, testThread, []byte(testDat1))
+	c, _ := newFakeClient(t, cache, Options{}, func(req *http.Request) (*http.Response, error) {
+		return nil, reset
+	})
+	g2ch, _ := c.NewGet2ch(testBoard, testThread)
+	res, err := g2ch.Fetch()
+	var serr *HTTPStatusError
+	if !errors.As(err, &serr) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Fetch() err = %v", err)
+	}
+	var terr *TimeoutError
+	if errors.As(err, &terr) {
+		t.Errorf("Fetch() err = %v, want not timeout", err)
+	}
+	if res.Source != SOURCE_CACHE || string(res.Data) != testDat1 {
+		t.Errorf("Fetch() = %v %q", res.Source, res.Data)
+	}
+	if g2ch.GetError() == nil {
+		t.Error("GetError() = nil")
+	}
+}
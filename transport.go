@@ -0,0 +1,116 @@
+package get2ch
+
+import (
+	"github.com/tanaton/get2ch-go/unlib"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MAX_IDLE_CONNS_PER_HOST = 4                // ホスト毎に保持する接続数
+	IDLE_CONN_TIMEOUT       = 90 * time.Second // 未使用の接続を切るまでの時間
+	KEEP_ALIVE_PERIOD       = 30 * time.Second
+)
+
+// 接続の再利用状況
+type ConnStats struct {
+	Requests uint64 // 接続を取得した回数
+	Reused   uint64 // 保持していた接続を再利用した回数
+	Created  uint64 // 新しく接続した回数
+}
+
+type connStats struct {
+	requests uint64
+	reused   uint64
+}
+
+func (cs *connStats) gotConn(info httptrace.GotConnInfo) {
+	atomic.AddUint64(&cs.requests, 1)
+	if info.Reused {
+		atomic.AddUint64(&cs.reused, 1)
+	}
+}
+
+// 接続の取得を数えるRoundTripper
+type statsTransport struct {
+	rt    http.RoundTripper
+	stats *connStats
+}
+
+func (st *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: st.stats.gotConn,
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return st.rt.RoundTrip(req)
+}
+
+// Client内で共有するTransport
+func (c *Client) newTransport(opt Options) *http.Transport {
+	idle := opt.MaxIdleConnsPerHost
+	if idle <= 0 {
+		idle = MAX_IDLE_CONNS_PER_HOST
+	}
+	connect := c.timeouts.Connect
+	if connect < 0 {
+		// 負の場合は制限しない
+		connect = 0
+	}
+	dialer := &net.Dialer{
+		Timeout:   connect,
+		KeepAlive: KEEP_ALIVE_PERIOD,
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		DisableKeepAlives:   opt.DisableKeepAlives,
+		DisableCompression:  true, // 圧縮解凍は全てこっちで指示する
+		MaxIdleConnsPerHost: idle,
+		IdleConnTimeout:     IDLE_CONN_TIMEOUT,
+	}
+}
+
+// 指定されたクライアントを複製して使う
+// バーボン判定のためCheckRedirectは常に上書きする
+func (c *Client) newHttpClient(opt Options) *http.Client {
+	// タイムアウトはroundTripで見張る
+	hc := &http.Client{}
+	if opt.HTTPClient != nil {
+		*hc = *opt.HTTPClient
+	}
+	rt := hc.Transport
+	if opt.Transport != nil {
+		rt = opt.Transport
+	}
+	if rt == nil {
+		if opt.HTTPClient != nil {
+			rt = http.DefaultTransport
+		} else {
+			rt = c.newTransport(opt)
+		}
+	}
+	hc.Transport = &statsTransport{rt: rt, stats: &c.stats}
+	hc.CheckRedirect = unlib.RedirectPolicy
+	return hc
+}
+
+// 接続を維持しない設定の場合は明示する
+func (c *Client) setConnection(req *http.Request) {
+	if !c.keepalive {
+		req.Header.Set("Connection", "close")
+	}
+}
+
+// 接続の再利用状況を返す
+func (c *Client) ConnStats() ConnStats {
+	req := atomic.LoadUint64(&c.stats.requests)
+	reused := atomic.LoadUint64(&c.stats.reused)
+	return ConnStats{
+		Requests: req,
+		Reused:   reused,
+		Created:  req - reused,
+	}
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package unlib
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// 他のプロセスと共有するファイルロック
+// exclusiveがfalseの場合は共有ロック
+func LockFile(fp *os.File, exclusive bool) error {
+	return flock(fp, lockHow(exclusive))
+}
+
+// ロックできない場合は待たずにfalseを返す
+func TryLockFile(fp *os.File, exclusive bool) (bool, error) {
+	err := flock(fp, lockHow(exclusive)|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func UnlockFile(fp *os.File) error {
+	return flock(fp, syscall.LOCK_UN)
+}
+
+func lockHow(exclusive bool) int {
+	if exclusive {
+		return syscall.LOCK_EX
+	}
+	return syscall.LOCK_SH
+}
+
+func flock(fp *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(fp.Fd()), how)
+		if err != syscall.EINTR {
+			if err != nil {
+				return &os.PathError{Op: "flock", Path: fp.Name(), Err: err}
+			}
+			return nil
+		}
+	}
+}
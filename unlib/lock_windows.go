@@ -0,0 +1,46 @@
+package unlib
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// 他のプロセスと共有するファイルロック
+// exclusiveがfalseの場合は共有ロック
+func LockFile(fp *os.File, exclusive bool) error {
+	return lockFileEx(fp, lockFlags(exclusive))
+}
+
+// ロックできない場合は待たずにfalseを返す
+func TryLockFile(fp *os.File, exclusive bool) (bool, error) {
+	err := lockFileEx(fp, lockFlags(exclusive)|windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func UnlockFile(fp *os.File) error {
+	ol := new(windows.Overlapped)
+	if err := windows.UnlockFileEx(windows.Handle(fp.Fd()), 0, ^uint32(0), ^uint32(0), ol); err != nil {
+		return &os.PathError{Op: "UnlockFileEx", Path: fp.Name(), Err: err}
+	}
+	return nil
+}
+
+func lockFlags(exclusive bool) uint32 {
+	if exclusive {
+		return windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	return 0
+}
+
+// ファイル全体をロックする
+func lockFileEx(fp *os.File, flags uint32) error {
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(windows.Handle(fp.Fd()), flags, 0, ^uint32(0), ^uint32(0), ol); err != nil {
+		return &os.PathError{Op: "LockFileEx", Path: fp.Name(), Err: err}
+	}
+	return nil
+}
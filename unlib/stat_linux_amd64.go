@@ -1,6 +1,7 @@
 package unlib
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -8,7 +9,8 @@ func Stat(filename string) (*Stat_t, error) {
 	var stat syscall.Stat_t
 	err := syscall.Stat(filename, &stat)
 	if err != nil {
-		return nil, err
+		// os.Statと同じくerrors.Is(err, os.ErrNotExist)で判定できるようにする
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: err}
 	}
 	return &Stat_t{
 		Size:  stat.Size,
@@ -3,11 +3,10 @@ package unlib
 // 細かい便利機能
 
 import (
-	"code.google.com/p/mahonia"
-	"bytes"
+	"errors"
+	"github.com/tanaton/get2ch-go/internal/codec"
 	"io"
 	"net/http"
-	"net/url"
 	"time"
 )
 
@@ -25,21 +24,25 @@ func CreateDateString(mod int64) string {
 	return time.Unix(mod, 0).Format("2006/01/02(Mon) 15:04:05")
 }
 
+// 変換できない文字は置換する
+var sjis = codec.NewCP932(codec.MODE_REPLACE)
+
 func ShiftJISToUtf8(data []byte) []byte {
-	return []byte(mahonia.NewDecoder("cp932").ConvertString(string(data)))
+	d, _ := sjis.Decode(data)
+	return d
 }
 func ShiftJISToUtf8Reader(r io.Reader) io.Reader {
-	return mahonia.NewDecoder("cp932").NewReader(r)
+	return sjis.NewReader(r)
 }
 
 func Utf8ToShiftJIS(data []byte) []byte {
-	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
-	enc := mahonia.NewEncoder("cp932")
-	enc.NewWriter(buf).Write(data)
-	return buf.Bytes()
+	d, _ := sjis.Encode(data)
+	return d
 }
-func Utf8ToShiftJISWriter(w io.Writer) io.Writer {
-	return mahonia.NewEncoder("cp932").NewWriter(w)
+
+// Closeで残りを書き出す
+func Utf8ToShiftJISWriter(w io.Writer) io.WriteCloser {
+	return sjis.NewWriter(w)
 }
 
 type RedirectError struct {
@@ -57,12 +60,9 @@ func RedirectPolicy(r *http.Request, _ []*http.Request) error {
 }
 
 func GetRedirectError(err error) *RedirectError {
-	uerr, uok := err.(*url.Error)
-	if !uok {
-		return nil
-	}
-	rerr, rok := uerr.Err.(*RedirectError)
-	if !rok {
+	// *url.Errorに包まれているので中まで探す
+	var rerr *RedirectError
+	if !errors.As(err, &rerr) {
 		return nil
 	}
 	return rerr